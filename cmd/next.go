@@ -2,19 +2,41 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 
 	"github.com/chavacava/next/internal/compressor"
 	"github.com/chavacava/next/internal/table"
 )
 
+var coders = map[string]compressor.Coder{
+	"huffman":    compressor.CoderHuffman,
+	"arithmetic": compressor.CoderArithmetic,
+	"rans":       compressor.CoderRANS,
+}
+
 func main() {
 	doCompress := flag.Bool("c", false, "compress the input")
 	doExpand := flag.Bool("e", false, "expand the input")
 	input := flag.String("i", "", "input file name (defaults to stdin)")
 	output := flag.String("o", "", "output file name (defaults to stdout)")
+	order := flag.Int("k", 1, "context order, number of bytes of a state (compression only)")
+	blended := flag.Bool("b", false, "blend context orders from k down to 0 (compression only)")
+	coderName := flag.String("coder", "huffman", "entropy coder of the transitions: huffman, arithmetic or rans (compression only, huffman only with -a)")
+	adaptive := flag.Bool("a", false, "adaptive blended model, no transitions table is stored and the input is read once (compression only)")
+	comment := flag.String("comment", "", "comment stored in the compressed file (compression only)")
+	blockSize := flag.Int("block", 0, "compress the input in independent blocks of the given size in bytes, 0 for a single block (compression only)")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of blocks compressed concurrently (compression in blocks only)")
+	recover := flag.Bool("recover", false, "skip the damaged blocks of a file compressed in blocks (expansion only)")
+	rangeOffset := flag.Int64("offset", 0, "offset of the expanded range of content, the input being a file compressed in blocks (expansion only)")
+	rangeLength := flag.Int64("length", -1, "length of the expanded range of content, -1 up to the end (expansion only)")
+	restore := flag.Bool("n", false, "restore the original file name (if the input is a file and no output file name is given) and modification time (expansion only)")
+	force := flag.Bool("f", false, "overwrite an existing file of the restored name (expansion only, with -n)")
 	flag.Parse()
 
 	var err error
@@ -27,51 +49,220 @@ func main() {
 		defer reader.Close()
 	}
 
-	writer := os.Stdout
-	if *output != "" {
-		writer, err = os.Create(*output)
-		if err != nil {
-			panic(err.Error())
-		}
-	}
-
 	if (*doCompress) && (*doExpand) {
 		panic("can not do both compress and expand")
 	}
 	if !(*doCompress || *doExpand) {
 		panic("you should ask for compressing or expanding the input")
 	}
+	if *order < 1 || *order > 255 {
+		panic("context order should be between 1 and 255")
+	}
+	coder, ok := coders[*coderName]
+	if !ok {
+		panic(fmt.Sprintf("unknown coder %q", *coderName))
+	}
 
 	switch {
 	case *doCompress:
-		t := table.New(reader)
+		if *blockSize < 0 {
+			panic("block size should not be negative")
+		}
+		writer := create(*output)
+		newTable := table.New
+		switch {
+		case *adaptive:
+			if coder != compressor.CoderHuffman {
+				panic("the adaptive model only supports the huffman coder")
+			}
+			newTable = func(_ io.ReadSeeker, order int) table.TransitionsTable { return table.NewAdaptive(order) }
+		case *blended:
+			newTable = table.NewBlended
+		}
 
-		cx := compressor.NewCompressor(t)
+		metadata := compressor.Metadata{Comment: *comment}
+		if *input != "" {
+			info, err := reader.Stat()
+			if err != nil {
+				panic(err.Error())
+			}
+			metadata.Name = filepath.Base(*input)
+			metadata.ModTime = info.ModTime()
+			metadata.Mode = info.Mode()
+		}
 
-		encoded := new(bytes.Buffer)
-		_, err := reader.Seek(0, 0)
+		// inputs that are not seekable (pipes) are spooled by the compressor
+		counter := &countingReader{r: reader}
+		encoded := &countingWriter{w: writer}
+		if *blockSize > 0 {
+			c := compressor.NewBlockCompressor(newTable, *order, coder, *blockSize).WithWorkers(*workers)
+			err = c.WithMetadata(metadata).Compress(counter, encoded)
+		} else {
+			model := compressor.ModelTransducer
+			switch {
+			case *adaptive:
+				model = compressor.ModelAdaptive
+			case *blended:
+				model = compressor.ModelBlended
+			}
+			err = compressor.NewStreamCompressor(model, *order, coder).WithMetadata(metadata).Compress(counter, encoded)
+		}
 		if err != nil {
 			panic(err.Error())
 		}
-		err = cx.Compress(reader, encoded)
+
+		err = writer.Close()
 		if err != nil {
 			panic(err.Error())
 		}
 
-		_, err = writer.Write(encoded.Bytes())
+		// statistics are not mixed with the compressed content written to stdout
+		fmt.Fprintf(os.Stderr, "original %d bytes\n", counter.n)
+		fmt.Fprintf(os.Stderr, "encoded %d bytes\n", encoded.n)
+		if counter.n > 0 {
+			fmt.Fprintf(os.Stderr, "ratio %v %%\n", (1.0-float32(encoded.n)/float32(counter.n))*100)
+		}
+	case *doExpand:
+		// the header is read ahead to know the original file name
+		headerBytes := new(bytes.Buffer)
+		header, err := compressor.ReadHeader(io.TeeReader(reader, headerBytes))
 		if err != nil {
 			panic(err.Error())
 		}
-		writer.Close()
 
-		fmt.Printf("original %d bytes\n", t.InputSize)
-		fmt.Printf("encoded %d bytes\n", len(encoded.Bytes()))
-		fmt.Printf("ratio %v %%\n", (1.0-float32(len(encoded.Bytes()))/float32(t.InputSize))*100)
-	case *doExpand:
-		dx := compressor.NewDecompressor()
-		err := dx.Decompress(reader, writer)
+		outputName := *output
+		name := filepath.Base(header.Metadata.Name)
+		if *restore && outputName == "" && *input != "" && name != "." && name != ".." && name != string(filepath.Separator) {
+			outputName = filepath.Join(filepath.Dir(*input), name)
+			checkRestoredName(reader, outputName, *force)
+		}
+
+		writer := create(outputName)
+		if *rangeOffset != 0 || *rangeLength >= 0 {
+			err = expandRange(reader, header, *rangeOffset, *rangeLength, writer)
+		} else {
+			dx := compressor.NewDecompressor()
+			decompress := dx.Decompress
+			if *recover {
+				decompress = dx.Recover
+			}
+			err = decompress(io.MultiReader(headerBytes, reader), writer)
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+
+		err = writer.Close()
 		if err != nil {
 			panic(err.Error())
 		}
+
+		if *restore && outputName != "" && !header.Metadata.ModTime.IsZero() {
+			err = os.Chtimes(outputName, header.Metadata.ModTime, header.Metadata.ModTime)
+			if err != nil {
+				panic(err.Error())
+			}
+		}
 	}
 }
+
+// checkRestoredName panics if the restored file name is the name of the input file,
+// or the name of an existing file that is not to be overwritten
+func checkRestoredName(input *os.File, name string, force bool) {
+	target, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+
+	info, err := input.Stat()
+	if err != nil {
+		panic(err.Error())
+	}
+	if os.SameFile(info, target) {
+		panic(fmt.Sprintf("the restored file name %q is the name of the input file", name))
+	}
+	if !force {
+		panic(fmt.Sprintf("the restored file %q already exists, use -f to overwrite it", name))
+	}
+}
+
+// expandRange writes in w the given range of the content of the framed file f, only the blocks of the range are decoded.
+// The file header is already read
+func expandRange(f *os.File, header compressor.Header, offset, length int64, w io.Writer) error {
+	if header.BlockSize == 0 {
+		return errors.New("the input is not compressed in blocks (-block), its ranges can not be expanded")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("the input is not a regular file (a pipe?), its ranges can not be expanded")
+	}
+	br, err := compressor.NewBlockReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	if length < 0 {
+		length = br.Size() - offset
+	}
+	_, err = io.Copy(w, io.NewSectionReader(br, offset, length))
+
+	return err
+}
+
+// create yields the file of the given name, stdout if the name is empty
+func create(name string) *os.File {
+	if name == "" {
+		return os.Stdout
+	}
+
+	result, err := os.Create(name)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return result
+}
+
+// countingReader counts the bytes of the underlying reader, up to the furthest offset read
+// as the compressor may read the input twice
+type countingReader struct {
+	r   io.ReadSeeker
+	pos int64
+	n   int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.pos += int64(n)
+	if cr.pos > cr.n {
+		cr.n = cr.pos
+	}
+	return n, err
+}
+
+// Seek lets the compressor know the size of the input and read it twice, if the underlying reader is seekable
+func (cr *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := cr.r.Seek(offset, whence)
+	if err == nil {
+		cr.pos = pos
+	}
+	return pos, err
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
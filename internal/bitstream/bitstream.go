@@ -2,7 +2,7 @@ package bitstream
 
 import (
 	"fmt"
-	"math"
+	"strings"
 
 	"github.com/chavacava/next/internal/types"
 )
@@ -13,15 +13,17 @@ type Bit bool
 // BitStream represents a stream of bits
 // Use one of the available constructors to instantiate it
 type BitStream struct {
-	bits []Bit
-	idx  types.Position
+	words []uint64 // bits packed from the most significant bit of the first word
+	size  types.Position
+	idx   types.Position
 }
 
 const byteSize = 8
+const wordSize = 64
 
 // New yields a new, empty, BitStream
 func New() BitStream {
-	return BitStream{[]Bit{}, 0}
+	return BitStream{}
 }
 
 // NewFromFullByte yields a bitstream from the given byte, thus the bitstream length will 8
@@ -31,64 +33,103 @@ func NewFromFullByte(b byte) BitStream {
 
 // NewFromByte creates a bitStream of the given size < 8 from the given byte b
 func NewFromByte(b byte, size byte) BitStream {
-	result := New()
-	bits := fmt.Sprintf("%08b", b)
-
-	if math.Log2(float64(b)) > float64(size) {
+	if size < byteSize && b >= 1<<size {
 		panic(fmt.Sprintf("canont represent %v in %d bits", b, size))
 	}
 
-	fitted := bits[len(bits)-int(size):]
-	for _, b := range fitted {
-		result.bits = append(result.bits, b == '1')
-	}
+	result := New()
+	result.appendBits(uint64(b), uint(size))
 
 	return result
 }
 
 // NewFromBits yields a bitstream containing the given bits
 func NewFromBits(bits []Bit) BitStream {
-	bs := New()
-	newBits := make([]Bit, len(bits))
-	copy(newBits, bits)
-	bs.bits = newBits
+	result := New()
+	for _, b := range bits {
+		v := uint64(0)
+		if b {
+			v = 1
+		}
+		result.appendBits(v, 1)
+	}
 
-	return bs
+	return result
 }
 
 // newFromBytes yields a bitstream containing the bits of the given bytes
 func newFromBytes(bytes []byte) BitStream {
 	result := New()
 	for _, b := range bytes {
-		newBS := NewFromFullByte(b)
-		result.Append(newBS)
+		result.appendBits(uint64(b), byteSize)
 	}
 
 	return result
 }
 
+// appendBits appends the n <= 64 least significant bits of v to this stream
+func (bs *BitStream) appendBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	if n < wordSize {
+		v &= 1<<n - 1
+	}
+
+	used := uint(bs.size % wordSize)
+	if used == 0 {
+		bs.words = append(bs.words, 0)
+	}
+
+	last := len(bs.words) - 1
+	free := wordSize - used
+	if n <= free {
+		bs.words[last] |= v << (free - n)
+	} else {
+		bs.words[last] |= v >> (n - free)
+		bs.words = append(bs.words, v<<(wordSize-(n-free)))
+	}
+
+	bs.size += types.Position(n)
+}
+
+// peekBits yields the n <= 64 bits of this stream starting at position pos,
+// the first bit being the most significant one. Bits beyond the end of the stream are zeros
+func (bs BitStream) peekBits(pos types.Position, n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+
+	w, offset := int(pos/wordSize), uint(pos%wordSize)
+	var result uint64
+	if w < len(bs.words) {
+		result = bs.words[w] << offset
+	}
+	if offset != 0 && w+1 < len(bs.words) {
+		result |= bs.words[w+1] >> (wordSize - offset)
+	}
+
+	return result >> (wordSize - n)
+}
+
 // Append appends the given bitstream to this one
 func (bs *BitStream) Append(other BitStream) {
-	bs.bits = append(bs.bits, other.bits...)
+	for i, w := range other.words {
+		n := uint(wordSize)
+		if i == len(other.words)-1 && other.size%wordSize != 0 {
+			n = uint(other.size % wordSize)
+			w >>= wordSize - n
+		}
+		bs.appendBits(w, n)
+	}
 }
 
 // Bytes yields the slice of bytes representation of this bitstream
 // the last byte might need to be padded with 0s
 func (bs BitStream) Bytes() []byte {
-	result := make([]byte, 0)
-	i := 0
-	for ; i+byteSize <= len(bs.bits); i += byteSize {
-		slice := bs.bits[i : i+byteSize]
-		result = append(result, NewFromBits(slice).Byte())
-	}
-
-	rest := bs.bits[i:]
-	if len(rest) != 0 {
-		// pad
-		for len(rest) < byteSize { // pad the byte
-			rest = append(rest, Bit(false))
-		}
-		result = append(result, NewFromBits(rest).Byte())
+	result := make([]byte, (bs.size+byteSize-1)/byteSize)
+	for i := range result {
+		result[i] = byte(bs.words[i/8] >> (wordSize - byteSize*uint(i%8+1)))
 	}
 
 	return result
@@ -97,26 +138,24 @@ func (bs BitStream) Bytes() []byte {
 // Read yields the next bit of this bitstream
 // Error will arise if there is no more bits to read
 func (bs *BitStream) Read() (bool, error) {
-	if bs.idx >= types.Position(len(bs.bits)) {
-		return false, fmt.Errorf("reading position %v out of range %v", bs.idx, len(bs.bits)-1)
+	if bs.idx >= bs.size {
+		return false, fmt.Errorf("reading position %v out of range %v", bs.idx, int64(bs.size)-1)
 	}
 
-	b := bs.bits[bs.idx]
+	b := bs.words[bs.idx/wordSize] >> (wordSize - 1 - bs.idx%wordSize) & 1
 	bs.idx++
 
-	return bool(b), nil
+	return b == 1, nil
 }
 
 // ReadByte yields the byte representation of the next 8 bits of this stream.
 // Error will arise if there is not at least 8 bits to read
 func (bs *BitStream) ReadByte() (byte, error) {
-	if bs.idx+byteSize-1 >= types.Position(len(bs.bits)) {
-		return 0, fmt.Errorf("reading positions %v to %v out of range %v", bs.idx, bs.idx+byteSize-1, len(bs.bits)-1)
+	if bs.idx+byteSize > bs.size {
+		return 0, fmt.Errorf("reading positions %v to %v out of range %v", bs.idx, bs.idx+byteSize-1, int64(bs.size)-1)
 	}
 
-	next8bits := bs.bits[bs.idx : bs.idx+byteSize]
-	result := NewFromBits(next8bits).Byte()
-
+	result := byte(bs.peekBits(bs.idx, byteSize))
 	bs.idx += byteSize
 
 	return result, nil
@@ -125,36 +164,125 @@ func (bs *BitStream) ReadByte() (byte, error) {
 // Byte yields the byte representation of this stream
 // Error will arise if the length of the stream is bigger than 8
 func (bs BitStream) Byte() byte {
-	bitCount := len(bs.bits)
-	if bitCount > 8 {
-		panic(fmt.Sprintf("cannot convert into byte, bitstream too long %d", bitCount))
+	if bs.size > byteSize {
+		panic(fmt.Sprintf("cannot convert into byte, bitstream too long %d", bs.size))
 	}
 
-	applicablePows := []byte{128, 64, 32, 16, 8, 4, 2, 1}
-	offset := byteSize - bitCount
-	result := byte(0)
-	for i := bitCount - 1; i >= 0; i-- {
-		b := bs.bits[i]
-		if b {
-			result += applicablePows[offset+i]
-		}
-	}
-
-	return result
+	return byte(bs.peekBits(0, uint(bs.size)))
 }
 
 // IsEqual returns true if this stream contains the same bits and
 // in the same order than the given other stream
 func (bs BitStream) IsEqual(other BitStream) bool {
-	if len(bs.bits) != len(other.bits) {
+	if bs.size != other.size {
 		return false
 	}
 
-	for i, b := range bs.bits {
-		if b != other.bits[i] {
+	for i, w := range bs.words {
+		if w != other.words[i] {
 			return false
 		}
 	}
 
 	return true
 }
+
+// String yields the bits of this stream as a sequence of 0s and 1s
+func (bs BitStream) String() string {
+	var result strings.Builder
+	for i := types.Position(0); i < bs.size; i++ {
+		result.WriteByte('0' + byte(bs.peekBits(i, 1)))
+	}
+
+	return result.String()
+}
+
+// Peek yields the next n (at most 64) bits of this stream without reading them,
+// the first bit being the most significant one, and the number of those bits actually available.
+// Bits beyond the end of the stream are zeros
+func (bs BitStream) Peek(n int) (uint64, int) {
+	if n > wordSize {
+		panic(fmt.Sprintf("cannot peek %d bits, at most 64", n))
+	}
+
+	available := n
+	if rest := int64(bs.size) - int64(bs.idx); rest < int64(n) {
+		available = int(rest)
+	}
+	if available < 0 {
+		available = 0
+	}
+
+	return bs.peekBits(bs.idx, uint(n)), available
+}
+
+// Skip skips the next n bits of this stream
+// Error will arise if there is less than n bits to read
+func (bs *BitStream) Skip(n int) error {
+	if bs.idx+types.Position(n) > bs.size {
+		return fmt.Errorf("skipping positions %v to %v out of range %v", bs.idx, bs.idx+types.Position(n)-1, int64(bs.size)-1)
+	}
+
+	bs.idx += types.Position(n)
+
+	return nil
+}
+
+// Len yields the number of bits of this stream
+func (bs BitStream) Len() int {
+	return int(bs.size)
+}
+
+// Remaining yields the number of bits left to read from this stream
+func (bs BitStream) Remaining() int {
+	return int(bs.size - bs.idx)
+}
+
+// WriteBits appends the n (at most 64) least significant bits of v to this stream, most significant bit first
+func (bs *BitStream) WriteBits(v uint64, n uint) {
+	if n > wordSize {
+		panic(fmt.Sprintf("cannot write %d bits, at most 64", n))
+	}
+
+	bs.appendBits(v, n)
+}
+
+// ReadBits yields the value of the next n (at most 64) bits of this stream, the first bit being the most significant one.
+// Error will arise if there is less than n bits to read
+func (bs *BitStream) ReadBits(n uint) (uint64, error) {
+	if n > wordSize {
+		panic(fmt.Sprintf("cannot read %d bits, at most 64", n))
+	}
+	if bs.idx+types.Position(n) > bs.size {
+		return 0, fmt.Errorf("reading positions %v to %v out of range %v", bs.idx, bs.idx+types.Position(n)-1, int64(bs.size)-1)
+	}
+
+	result := bs.peekBits(bs.idx, n)
+	bs.idx += types.Position(n)
+
+	return result, nil
+}
+
+// AlignToByte appends 0s to this stream until its length is a multiple of 8
+func (bs *BitStream) AlignToByte() {
+	if rest := bs.size % byteSize; rest != 0 {
+		bs.appendBits(0, uint(byteSize-rest))
+	}
+}
+
+// Position yields the reading position of this stream, to be restored with SetPosition
+func (bs BitStream) Position() types.Position {
+	return bs.idx
+}
+
+// SetPosition sets the reading position of this stream
+// Error will arise if the position is beyond the end of the stream
+func (bs *BitStream) SetPosition(p types.Position) error {
+	if p > bs.size {
+		return fmt.Errorf("position %v out of range %v", p, bs.size)
+	}
+
+	bs.idx = p
+
+	return nil
+}
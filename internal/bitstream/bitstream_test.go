@@ -1,8 +1,10 @@
 package bitstream
 
 import (
+	"bytes"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/chavacava/next/internal/types"
@@ -60,19 +62,19 @@ func TestNewFromBits(t *testing.T) {
 	}{
 		"empty": {
 			bits: []Bit{},
-			want: BitStream{bits: []Bit{}, idx: 0},
+			want: NewFromBits([]Bit{}),
 		},
 		"one false bit": {
 			bits: []Bit{false},
-			want: BitStream{bits: []Bit{false}, idx: 0},
+			want: NewFromBits([]Bit{false}),
 		},
 		"one true bit": {
 			bits: []Bit{true},
-			want: BitStream{bits: []Bit{true}, idx: 0},
+			want: NewFromBits([]Bit{true}),
 		},
 		"many bits": {
 			bits: []Bit{true, false, true, false, true, false, true, false, true, false, false},
-			want: BitStream{bits: []Bit{true, false, true, false, true, false, true, false, true, false, false}, idx: 0},
+			want: NewFromBits([]Bit{true, false, true, false, true, false, true, false, true, false, false}),
 		},
 	}
 
@@ -95,19 +97,19 @@ func TestAppend(t *testing.T) {
 		want  BitStream
 	}{
 		"empty": {
-			this:  BitStream{bits: []Bit{}, idx: 0},
-			other: BitStream{bits: []Bit{}, idx: 0},
-			want:  BitStream{bits: []Bit{}, idx: 0},
+			this:  NewFromBits([]Bit{}),
+			other: NewFromBits([]Bit{}),
+			want:  NewFromBits([]Bit{}),
 		},
 		"empty + non empty": {
-			this:  BitStream{bits: []Bit{}, idx: 0},
-			other: BitStream{bits: []Bit{true}, idx: 1},
-			want:  BitStream{bits: []Bit{true}, idx: 0},
+			this:  NewFromBits([]Bit{}),
+			other: readFrom(NewFromBits([]Bit{true}), 1),
+			want:  NewFromBits([]Bit{true}),
 		},
 		"non empty + non empty": {
-			this:  BitStream{bits: []Bit{true, true, true}, idx: 0},
-			other: BitStream{bits: []Bit{false, false}, idx: 1},
-			want:  BitStream{bits: []Bit{true, true, true, false, false}, idx: 0},
+			this:  NewFromBits([]Bit{true, true, true}),
+			other: readFrom(NewFromBits([]Bit{false, false}), 1),
+			want:  NewFromBits([]Bit{true, true, true, false, false}),
 		},
 	}
 
@@ -391,3 +393,326 @@ func TestReadByte(t *testing.T) {
 		)
 	}
 }
+
+func TestPeekAndSkip(t *testing.T) {
+	tt := map[string]struct {
+		bs            BitStream
+		idx           types.Position
+		n             int
+		want          uint64
+		wantAvailable int
+		wantErr       error
+	}{
+		"peek on empty bs": {
+			bs:            NewFromBits([]Bit{}),
+			idx:           0,
+			n:             3,
+			want:          0,
+			wantAvailable: 0,
+			wantErr:       errors.New(""),
+		},
+		"peek nothing": {
+			bs:            NewFromBits([]Bit{true}),
+			idx:           0,
+			n:             0,
+			want:          0,
+			wantAvailable: 0,
+			wantErr:       nil,
+		},
+		"peek 3 bits from pos 1": {
+			bs:            NewFromBits([]Bit{false, true, false, true, true}),
+			idx:           1,
+			n:             3,
+			want:          5,
+			wantAvailable: 3,
+			wantErr:       nil,
+		},
+		"peek beyond the end": {
+			bs:            NewFromBits([]Bit{false, true, true}),
+			idx:           1,
+			n:             4,
+			want:          12,
+			wantAvailable: 2,
+			wantErr:       errors.New(""),
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				tc.bs.idx = tc.idx
+				got, available := tc.bs.Peek(tc.n)
+
+				if tc.want != got || tc.wantAvailable != available {
+					t.Fatalf("expected %v (%v bits available), got %v (%v bits available)", tc.want, tc.wantAvailable, got, available)
+				}
+
+				err := tc.bs.Skip(tc.n)
+
+				if tc.wantErr != nil && err == nil {
+					t.Fatalf("error expected skipping %v bits at index %v in %v", tc.n, tc.idx, tc.bs)
+				}
+
+				if tc.wantErr == nil && err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				if tc.wantErr != nil {
+					return
+				}
+
+				if tc.bs.idx != tc.idx+types.Position(tc.n) {
+					t.Fatalf("expected index to increment by %v to %v, got %v", tc.n, tc.idx+types.Position(tc.n), tc.bs.idx)
+				}
+			},
+		)
+	}
+}
+
+// readFrom yields the given bitstream with its reading position set to idx
+func TestWriteReadBits(t *testing.T) {
+	type field struct {
+		v uint64
+		n uint
+	}
+	tt := map[string]struct {
+		fields []field
+		want   string
+	}{
+		"no bits": {
+			fields: []field{{0, 0}},
+			want:   "",
+		},
+		"small fields": {
+			fields: []field{{5, 3}, {0, 2}, {1, 1}},
+			want:   "101001",
+		},
+		"only least significant bits": {
+			fields: []field{{0xff, 4}},
+			want:   "1111",
+		},
+		"across word boundary": {
+			fields: []field{{0, 60}, {0x2d, 7}},
+			want:   strings.Repeat("0", 60) + "0101101",
+		},
+		"64 bits": {
+			fields: []field{{1, 1}, {0x8000000000000001, 64}},
+			want:   "11" + strings.Repeat("0", 62) + "1",
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				bs := New()
+				for _, f := range tc.fields {
+					bs.WriteBits(f.v, f.n)
+				}
+
+				if got := bs.String(); got != tc.want {
+					t.Fatalf("expected %v, got %v", tc.want, got)
+				}
+
+				for _, f := range tc.fields {
+					got, err := bs.ReadBits(f.n)
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					want := f.v
+					if f.n < 64 {
+						want &= 1<<f.n - 1
+					}
+					if got != want {
+						t.Fatalf("expected %v reading %v bits, got %v", want, f.n, got)
+					}
+				}
+
+				if bs.Remaining() != 0 {
+					t.Fatalf("expected no remaining bits, got %v", bs.Remaining())
+				}
+
+				if _, err := bs.ReadBits(1); err == nil {
+					t.Fatalf("error expected reading beyond the end of %v", bs)
+				}
+			},
+		)
+	}
+}
+
+func TestAlignAndPosition(t *testing.T) {
+	bs := New()
+	bs.WriteBits(5, 3)
+	bs.AlignToByte()
+	if bs.String() != "10100000" {
+		t.Fatalf("expected 10100000, got %v", bs)
+	}
+
+	bs.AlignToByte()
+	if bs.Len() != 8 {
+		t.Fatalf("expected aligned stream to stay 8 bits long, got %v", bs.Len())
+	}
+
+	if _, err := bs.ReadBits(2); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	p := bs.Position()
+	if p != 2 || bs.Remaining() != 6 {
+		t.Fatalf("expected position 2 with 6 remaining bits, got %v with %v", p, bs.Remaining())
+	}
+
+	v, _ := bs.ReadBits(3)
+	if err := bs.SetPosition(p); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if again, _ := bs.ReadBits(3); again != v {
+		t.Fatalf("expected %v after restoring the position, got %v", v, again)
+	}
+
+	if err := bs.SetPosition(9); err == nil {
+		t.Fatal("error expected setting a position beyond the end")
+	}
+}
+
+func readFrom(bs BitStream, idx types.Position) BitStream {
+	bs.idx = idx
+	return bs
+}
+
+// benchmarkSize is the number of bytes of the bitstreams of the benchmarks
+const benchmarkSize = 1 << 20
+
+func benchmarkStream() BitStream {
+	result := New()
+	for i := 0; i < benchmarkSize; i++ {
+		result.Append(NewFromFullByte(byte(i)))
+	}
+
+	return result
+}
+
+func BenchmarkAppendBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkStream()
+	}
+}
+
+func BenchmarkAppendBits(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bs := New()
+		for j := 0; j < benchmarkSize; j++ {
+			bs.Append(NewFromByte(byte(j&7), 3))
+		}
+	}
+}
+
+func BenchmarkReadByte(b *testing.B) {
+	bs := benchmarkStream()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := bs
+		for j := 0; j < benchmarkSize; j++ {
+			r.ReadByte()
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	bs := benchmarkStream()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := bs
+		for j := 0; j < benchmarkSize; j++ {
+			r.Read()
+		}
+	}
+}
+
+func BenchmarkBytes(b *testing.B) {
+	bs := benchmarkStream()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bs.Bytes()
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	tt := map[string]int{
+		"empty":                0,
+		"one value":            1,
+		"one buffer":           8 * bufferSize / 13,
+		"more than one buffer": 3 * 8 * bufferSize / 13,
+	}
+
+	for name, count := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				buf := new(bytes.Buffer)
+				w := NewWriter(buf)
+				for i := 0; i < count; i++ {
+					w.Append(NewFromByte(byte(i%32), 5))
+					w.Append(NewFromFullByte(byte(i)))
+				}
+				if err := w.Flush(); err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				if want := (count*13 + 7) / 8; buf.Len() != want {
+					t.Fatalf("expected %d bytes, got %d", want, buf.Len())
+				}
+
+				r := NewReader(buf)
+				for i := 0; i < count; i++ {
+					v, available := r.Peek(5)
+					if available != 5 || v != uint64(i%32) {
+						t.Fatalf("expected to peek %v, got %v (%d bits available)", i%32, v, available)
+					}
+					if err := r.Skip(5); err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					b, err := r.ReadByte()
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					if b != byte(i) {
+						t.Fatalf("expected %v, got %v", byte(i), b)
+					}
+				}
+
+				// padding bits
+				for i := 0; i < (8-count*13%8)%8; i++ {
+					if b, err := r.Read(); err != nil || b {
+						t.Fatalf("expected a 0 padding bit, got %v (error %v)", b, err)
+					}
+				}
+
+				if _, err := r.Read(); err == nil {
+					t.Fatalf("expected error reading beyond the end of the stream")
+				}
+			},
+		)
+	}
+}
+
+// failingIO fails to read and write
+type failingIO struct{}
+
+func (failingIO) Read(p []byte) (int, error)  { return 0, errors.New("read failure") }
+func (failingIO) Write(p []byte) (int, error) { return 0, errors.New("write failure") }
+
+func TestWriterReaderErrors(t *testing.T) {
+	w := NewWriter(failingIO{})
+	w.Append(NewFromFullByte(1))
+	if err := w.Flush(); err == nil || err.Error() != "write failure" {
+		t.Fatalf("expected write failure, got %v", err)
+	}
+
+	r := NewReader(failingIO{})
+	if _, err := r.ReadByte(); err == nil || err.Error() != "read failure" {
+		t.Fatalf("expected read failure, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package bitstream
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxVarintBytes is the maximum number of bytes of the LEB128 encoding of a 64 bits value
+const maxVarintBytes = 10
+
+// bitLength yields the number of bits of v without its leading zeros
+func bitLength(v uint64) uint {
+	n := uint(0)
+	for ; v != 0; v >>= 1 {
+		n++
+	}
+
+	return n
+}
+
+// WriteGamma writes the Elias gamma code of v: the bit length of v minus one as zeros, followed by the bits of v.
+// v must be greater than 0
+func WriteGamma(w BitWriter, v uint64) {
+	if v == 0 {
+		panic("Elias gamma code of 0")
+	}
+
+	n := bitLength(v) - 1
+	w.WriteBits(0, n)
+	w.WriteBits(v, n+1)
+}
+
+// ReadGamma reads an Elias gamma coded value.
+// Error will arise if the code is truncated or longer than 64 bits of value
+func ReadGamma(r BitReader) (uint64, error) {
+	n := uint(0)
+	for {
+		b, err := r.Read()
+		if err != nil {
+			return 0, err
+		}
+		if b {
+			break
+		}
+		n++
+		if n >= wordSize {
+			return 0, errors.New("Elias gamma code too long")
+		}
+	}
+
+	rest, err := r.ReadBits(n)
+	if err != nil {
+		return 0, err
+	}
+
+	return 1<<n | rest, nil
+}
+
+// WriteDelta writes the Elias delta code of v: the Elias gamma code of the bit length of v,
+// followed by the bits of v but its leading one.
+// v must be greater than 0
+func WriteDelta(w BitWriter, v uint64) {
+	if v == 0 {
+		panic("Elias delta code of 0")
+	}
+
+	n := bitLength(v)
+	WriteGamma(w, uint64(n))
+	w.WriteBits(v, n-1)
+}
+
+// ReadDelta reads an Elias delta coded value.
+// Error will arise if the code is truncated or longer than 64 bits of value
+func ReadDelta(r BitReader) (uint64, error) {
+	n, err := ReadGamma(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > wordSize {
+		return 0, fmt.Errorf("Elias delta code of a %d bits value", n)
+	}
+
+	rest, err := r.ReadBits(uint(n - 1))
+	if err != nil {
+		return 0, err
+	}
+
+	return 1<<uint(n-1) | rest, nil
+}
+
+// WriteExpGolomb writes the Exp-Golomb code of order k of v:
+// the Elias gamma code of (v >> k) + 1 followed by the k least significant bits of v.
+// v >> k must be less than 2^64 - 1
+func WriteExpGolomb(w BitWriter, v uint64, k uint) {
+	WriteGamma(w, v>>k+1)
+	w.WriteBits(v, k)
+}
+
+// ReadExpGolomb reads an Exp-Golomb coded value of order k.
+// Error will arise if the code is truncated or its value does not fit in 64 bits
+func ReadExpGolomb(r BitReader, k uint) (uint64, error) {
+	q, err := ReadGamma(r)
+	if err != nil {
+		return 0, err
+	}
+	q--
+	if k > 0 && q > (1<<(wordSize-k))-1 {
+		return 0, errors.New("Exp-Golomb coded value overflows 64 bits")
+	}
+
+	rest, err := r.ReadBits(k)
+	if err != nil {
+		return 0, err
+	}
+
+	return q<<k | rest, nil
+}
+
+// WriteRice writes the Golomb-Rice code of parameter k of v:
+// v >> k in unary (ones ended by a zero) followed by the k least significant bits of v
+func WriteRice(w BitWriter, v uint64, k uint) {
+	for q := v >> k; q > 0; q-- {
+		w.WriteBits(1, 1)
+	}
+	w.WriteBits(0, 1)
+	w.WriteBits(v, k)
+}
+
+// ReadRice reads a Golomb-Rice coded value of parameter k.
+// Error will arise if the code is truncated or its value does not fit in 64 bits
+func ReadRice(r BitReader, k uint) (uint64, error) {
+	q := uint64(0)
+	for {
+		b, err := r.Read()
+		if err != nil {
+			return 0, err
+		}
+		if !b {
+			break
+		}
+		q++
+		if k > 0 && q > (1<<(wordSize-k))-1 {
+			return 0, errors.New("Golomb-Rice coded value overflows 64 bits")
+		}
+	}
+
+	rest, err := r.ReadBits(k)
+	if err != nil {
+		return 0, err
+	}
+
+	return q<<k | rest, nil
+}
+
+// WriteUvarint writes the LEB128 encoding of v: groups of 7 bits, least significant group first,
+// each in a byte whose most significant bit is set if more groups follow.
+// The bytes are the same as those of binary.PutUvarint
+func WriteUvarint(w BitWriter, v uint64) {
+	for v >= 0x80 {
+		w.WriteBits(v&0x7f|0x80, byteSize)
+		v >>= 7
+	}
+	w.WriteBits(v, byteSize)
+}
+
+// ReadUvarint reads a LEB128 encoded value.
+// Error will arise if the encoding is truncated or its value does not fit in 64 bits
+func ReadUvarint(r BitReader) (uint64, error) {
+	var result uint64
+	for i := uint(0); i < maxVarintBytes; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if i == maxVarintBytes-1 && b > 1 {
+			break
+		}
+
+		result |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return result, nil
+		}
+	}
+
+	return 0, errors.New("LEB128 coded value overflows 64 bits")
+}
@@ -0,0 +1,136 @@
+package bitstream
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestUniversalCodes(t *testing.T) {
+	type code struct {
+		write func(w BitWriter, v uint64)
+		read  func(r BitReader) (uint64, error)
+	}
+	codes := map[string]code{
+		"gamma": {WriteGamma, ReadGamma},
+		"delta": {WriteDelta, ReadDelta},
+		"exp-golomb 0": {
+			func(w BitWriter, v uint64) { WriteExpGolomb(w, v, 0) },
+			func(r BitReader) (uint64, error) { return ReadExpGolomb(r, 0) },
+		},
+		"exp-golomb 3": {
+			func(w BitWriter, v uint64) { WriteExpGolomb(w, v, 3) },
+			func(r BitReader) (uint64, error) { return ReadExpGolomb(r, 3) },
+		},
+		"rice 2": {
+			func(w BitWriter, v uint64) { WriteRice(w, v, 2) },
+			func(r BitReader) (uint64, error) { return ReadRice(r, 2) },
+		},
+		"uvarint": {WriteUvarint, ReadUvarint},
+	}
+
+	tt := map[string]struct {
+		code string
+		v    uint64
+		want string
+	}{
+		"gamma 1":          {"gamma", 1, "1"},
+		"gamma 5":          {"gamma", 5, "00101"},
+		"gamma max":        {"gamma", math.MaxUint64, ""},
+		"delta 1":          {"delta", 1, "1"},
+		"delta 10":         {"delta", 10, "00100010"},
+		"delta max":        {"delta", math.MaxUint64, ""},
+		"exp-golomb 0 0":   {"exp-golomb 0", 0, "1"},
+		"exp-golomb 0 4":   {"exp-golomb 0", 4, "00101"},
+		"exp-golomb 3 9":   {"exp-golomb 3", 9, "010001"},
+		"exp-golomb 3 big": {"exp-golomb 3", math.MaxUint64, ""},
+		"rice 2 0":         {"rice 2", 0, "000"},
+		"rice 2 9":         {"rice 2", 9, "11001"},
+		"uvarint 0":        {"uvarint", 0, "00000000"},
+		"uvarint 300":      {"uvarint", 300, "1010110000000010"},
+		"uvarint max":      {"uvarint", math.MaxUint64, ""},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				c := codes[tc.code]
+				bs := New()
+				c.write(&bs, tc.v)
+				bs.WriteBits(1, 1) // the reader must not read beyond the code
+
+				if tc.want != "" && bs.String() != tc.want+"1" {
+					t.Fatalf("expected %v, got %v", tc.want+"1", bs)
+				}
+
+				got, err := c.read(&bs)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if got != tc.v {
+					t.Fatalf("expected %v, got %v", tc.v, got)
+				}
+				if bs.Remaining() != 1 {
+					t.Fatalf("expected 1 remaining bit, got %v", bs.Remaining())
+				}
+
+				bs.SetPosition(0)
+				truncated := newFromBytes(nil)
+				for i := 0; i < bs.Len()-2; i++ {
+					b, _ := bs.Read()
+					truncated.Append(NewFromBits([]Bit{Bit(b)}))
+				}
+				if _, err := c.read(&truncated); err == nil {
+					t.Fatalf("error expected reading truncated code %v", truncated)
+				}
+			},
+		)
+	}
+}
+
+func TestUvarintMatchesBinary(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 16383, 16384, 1 << 35, math.MaxUint64} {
+		bs := New()
+		WriteUvarint(&bs, v)
+
+		buf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(buf, v)
+		if got := bs.Bytes(); string(got) != string(buf[:n]) {
+			t.Fatalf("expected %v for %v, got %v", buf[:n], v, got)
+		}
+	}
+}
+
+func TestInvalidCodes(t *testing.T) {
+	tt := map[string]struct {
+		bs   BitStream
+		read func(r BitReader) (uint64, error)
+	}{
+		"gamma longer than 64 bits": {
+			bs:   newFromBytes(make([]byte, 9)),
+			read: ReadGamma,
+		},
+		"delta of a 65 bits value": {
+			bs:   func() BitStream { bs := New(); WriteGamma(&bs, 65); bs.WriteBits(0, 64); return bs }(),
+			read: ReadDelta,
+		},
+		"uvarint overflow": {
+			bs:   newFromBytes([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}),
+			read: ReadUvarint,
+		},
+		"exp-golomb overflow": {
+			bs:   func() BitStream { bs := New(); WriteGamma(&bs, 1<<62); bs.WriteBits(0, 3); return bs }(),
+			read: func(r BitReader) (uint64, error) { return ReadExpGolomb(r, 3) },
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				if _, err := tc.read(&tc.bs); err == nil {
+					t.Fatal("error expected")
+				}
+			},
+		)
+	}
+}
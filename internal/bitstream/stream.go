@@ -0,0 +1,191 @@
+package bitstream
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/chavacava/next/internal/types"
+)
+
+// BitWriter is implemented by the destinations of bits: *BitStream and *Writer
+type BitWriter interface {
+	Append(other BitStream)
+	WriteBits(v uint64, n uint)
+	AlignToByte()
+}
+
+// BitReader is implemented by the sources of bits: *BitStream and *Reader
+type BitReader interface {
+	Read() (bool, error)
+	ReadByte() (byte, error)
+	ReadBits(n uint) (uint64, error)
+	Peek(n int) (uint64, int)
+	Skip(n int) error
+}
+
+// bufferSize is the number of bytes written to (read from) the underlying stream of a Writer (Reader) at once
+const bufferSize = 32 * 1024
+
+// Writer is a BitWriter writing the bits, packed in bytes, to an io.Writer.
+// Bits are buffered thus the writer must be flushed once all the bits are written.
+// Use the constructor to create new instances
+type Writer struct {
+	w   io.Writer
+	buf BitStream // bits not yet written
+	err error     // first error while writing to w
+}
+
+// NewWriter yields a new writer of bits to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Append appends the bits of the given bitstream to this writer.
+// Errors writing to the underlying writer are reported by Flush
+func (w *Writer) Append(other BitStream) {
+	w.buf.Append(other)
+	w.flushFullBuffer()
+}
+
+// WriteBits writes the n (at most 64) least significant bits of v, most significant bit first.
+// Errors writing to the underlying writer are reported by Flush
+func (w *Writer) WriteBits(v uint64, n uint) {
+	w.buf.WriteBits(v, n)
+	w.flushFullBuffer()
+}
+
+// AlignToByte writes 0s until the number of written bits is a multiple of 8
+func (w *Writer) AlignToByte() {
+	w.buf.AlignToByte()
+}
+
+// flushFullBuffer writes the buffered words to the underlying writer if the buffer is full
+func (w *Writer) flushFullBuffer() {
+	if w.buf.size >= 8*bufferSize {
+		w.write(int(w.buf.size / wordSize))
+	}
+}
+
+// write writes the first n words of the buffer to the underlying writer
+func (w *Writer) write(n int) {
+	if w.err == nil {
+		words := BitStream{words: w.buf.words[:n], size: types.Position(n * wordSize)}
+		_, w.err = w.w.Write(words.Bytes())
+	}
+
+	w.buf.words = append(w.buf.words[:0], w.buf.words[n:]...)
+	w.buf.size -= types.Position(n * wordSize)
+}
+
+// Flush writes all the appended bits to the underlying writer, the last byte is padded with 0s.
+// Error will arise if the bits could not be written
+func (w *Writer) Flush() error {
+	if w.err == nil && w.buf.size > 0 {
+		_, w.err = w.w.Write(w.buf.Bytes())
+	}
+	w.buf = New()
+
+	return w.err
+}
+
+// Reader is a BitReader reading the bits, packed in bytes, from an io.Reader.
+// Bytes are read from the underlying reader as needed, thus bytes might be read beyond the last read bit.
+// Use the constructor to create new instances
+type Reader struct {
+	r     io.Reader
+	buf   BitStream // bits read from r, bits before buf.idx are already consumed
+	chunk []byte
+	err   error // first error (or io.EOF) while reading from r
+}
+
+// NewReader yields a new reader of bits from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, chunk: make([]byte, bufferSize)}
+}
+
+// fill reads bytes from the underlying reader until at least n bits are available (or the end of r is reached)
+func (r *Reader) fill(n int) {
+	for int64(r.buf.size)-int64(r.buf.idx) < int64(n) && r.err == nil {
+		// drop consumed words
+		if consumed := int(r.buf.idx / wordSize); consumed > 0 {
+			r.buf.words = append(r.buf.words[:0], r.buf.words[consumed:]...)
+			r.buf.size -= types.Position(consumed * wordSize)
+			r.buf.idx -= types.Position(consumed * wordSize)
+		}
+
+		var k int
+		k, r.err = r.r.Read(r.chunk)
+		for _, b := range r.chunk[:k] {
+			r.buf.appendBits(uint64(b), byteSize)
+		}
+	}
+}
+
+// readError yields the error of reading n bits beyond the available ones
+func (r *Reader) readError(n int) error {
+	if r.err != nil && r.err != io.EOF {
+		return r.err
+	}
+
+	return fmt.Errorf("unable to read %d bits: %v", n, io.ErrUnexpectedEOF)
+}
+
+// Read yields the next bit
+// Error will arise if there is no more bits to read
+func (r *Reader) Read() (bool, error) {
+	r.fill(1)
+	b, err := r.buf.Read()
+	if err != nil {
+		return false, r.readError(1)
+	}
+
+	return b, nil
+}
+
+// ReadByte yields the byte representation of the next 8 bits.
+// Error will arise if there is not at least 8 bits to read
+func (r *Reader) ReadByte() (byte, error) {
+	r.fill(byteSize)
+	b, err := r.buf.ReadByte()
+	if err != nil {
+		return 0, r.readError(byteSize)
+	}
+
+	return b, nil
+}
+
+// ReadBits yields the value of the next n (at most 64) bits, the first bit being the most significant one.
+// Error will arise if there is less than n bits to read
+func (r *Reader) ReadBits(n uint) (uint64, error) {
+	r.fill(int(n))
+	v, err := r.buf.ReadBits(n)
+	if err != nil {
+		return 0, r.readError(int(n))
+	}
+
+	return v, nil
+}
+
+// AlignToByte skips the bits up to the next multiple of 8 bits read
+func (r *Reader) AlignToByte() error {
+	return r.Skip(int((byteSize - r.buf.idx%byteSize) % byteSize))
+}
+
+// Peek yields the next n (at most 64) bits without reading them,
+// the first bit being the most significant one, and the number of those bits actually available.
+// Bits beyond the end of the stream are zeros
+func (r *Reader) Peek(n int) (uint64, int) {
+	r.fill(n)
+	return r.buf.Peek(n)
+}
+
+// Skip skips the next n bits
+// Error will arise if there is less than n bits to read
+func (r *Reader) Skip(n int) error {
+	r.fill(n)
+	if err := r.buf.Skip(n); err != nil {
+		return r.readError(n)
+	}
+
+	return nil
+}
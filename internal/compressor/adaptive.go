@@ -0,0 +1,112 @@
+package compressor
+
+import (
+	"fmt"
+
+	"github.com/chavacava/next/internal/bitstream"
+	"github.com/chavacava/next/internal/compressor/encoders"
+	"github.com/chavacava/next/internal/table"
+)
+
+// adaptiveCoder encodes and decodes symbols with an adaptive table, thus starting from an empty transducer.
+// Once a symbol is encoded (or decoded) the counts of the states of its context are updated.
+// The Huffman code of a state is rebuilt from the counts each time the number of transitions
+// from the state reaches a power of 2.
+// A symbol that is not in the code of any of the states of its context is written as a raw byte.
+type adaptiveCoder struct {
+	tt     table.TransitionsTable
+	states map[table.State]adaptiveState
+}
+
+// adaptiveState is the current code of a state
+type adaptiveState struct {
+	nl    table.NextList
+	coder encoders.HuffmanBased
+}
+
+func newAdaptiveCoder(order int) adaptiveCoder {
+	return adaptiveCoder{
+		tt:     table.NewAdaptive(order),
+		states: map[table.State]adaptiveState{},
+	}
+}
+
+// encode encodes the symbol to following the given context
+func (c adaptiveCoder) encode(context table.State, to byte, bs bitstream.BitWriter) error {
+	err := c.write(context, to, bs)
+	if err != nil {
+		return err
+	}
+
+	return c.update(context, to)
+}
+
+// write writes the codes of the symbol to following the given context
+func (c adaptiveCoder) write(context table.State, to byte, bs bitstream.BitWriter) error {
+	for l := len(context); l >= 0; l-- {
+		st, exists := c.states[context[len(context)-l:]]
+		if !exists {
+			continue
+		}
+
+		if st.nl.Has(to) {
+			return st.coder.Encode(to, bs)
+		}
+
+		err := st.coder.Encode(st.nl.Escape.S, bs)
+		if err != nil {
+			return err
+		}
+	}
+
+	bs.WriteBits(uint64(to), 8)
+
+	return nil
+}
+
+// decode decodes the symbol following the given context
+func (c adaptiveCoder) decode(context table.State, bs bitstream.BitReader) (byte, error) {
+	for l := len(context); l >= 0; l-- {
+		st, exists := c.states[context[len(context)-l:]]
+		if !exists {
+			continue
+		}
+
+		s, err := st.coder.Decode(bs)
+		if err != nil {
+			return 0, err
+		}
+
+		if st.nl.Escape == nil || s != st.nl.Escape.S {
+			return s, c.update(context, s)
+		}
+	}
+
+	s, err := bs.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	return s, c.update(context, s)
+}
+
+// update counts the transition to the given symbol and rebuilds the codes of the updated states if needed
+func (c adaptiveCoder) update(context table.State, to byte) error {
+	var err error
+	c.tt.Update(context, to, func(s table.State, nl *table.NextList) {
+		total := nl.Total()
+		if err != nil || total&(total-1) != 0 {
+			return // previous state failed or not a power of 2
+		}
+
+		snapshot := nl.WithEscape()
+		coder, e := encoders.NewHuffmanBasedFromNextList(snapshot)
+		if e != nil {
+			err = fmt.Errorf("unable to build the code of state %v: %w", []byte(s), e)
+			return
+		}
+		c.states[s] = adaptiveState{nl: snapshot, coder: coder}
+	})
+
+	return err
+}
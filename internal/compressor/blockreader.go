@@ -0,0 +1,226 @@
+package compressor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+)
+
+// indexFooterSize is the number of bytes after the index entries: content length, index CRC and index offset
+const indexFooterSize = 8 + 4 + 8
+
+// BlockReader reads the content of a framed file from any offset, decoding only the blocks covering the read bytes.
+// It implements io.ReaderAt, io.Reader and io.Seeker. Use the constructor to create new instances
+type BlockReader struct {
+	r           io.ReaderAt
+	header      Header
+	index       []indexEntry
+	indexOffset int64
+	size        int64 // length of the whole content
+	offset      int64 // offset of the next Read
+
+	mu      sync.Mutex // guards the decoded block
+	decoded int        // index of the decoded block, -1 if none
+	block   *bytes.Buffer
+	content *bytes.Buffer
+}
+
+// NewBlockReader yields a reader of the content of the framed file of the given size read from r.
+// Error will arise if the file is not a framed file or if its header or index is damaged
+func NewBlockReader(r io.ReaderAt, size int64) (*BlockReader, error) {
+	header, err := ReadHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the file header: %v", err)
+	}
+	if header.BlockSize == 0 {
+		return nil, errors.New("not a framed file, it has no index of its blocks")
+	}
+
+	index, indexOffset, contentLength, err := readIndex(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the index: %w", err)
+	}
+
+	return &BlockReader{
+		r:           r,
+		header:      header,
+		index:       index,
+		indexOffset: indexOffset,
+		size:        contentLength,
+		decoded:     -1,
+		block:       new(bytes.Buffer),
+		content:     new(bytes.Buffer),
+	}, nil
+}
+
+// readIndex reads the index at the end of the framed file of the given size.
+// It yields the index entries, the offset of the index and the length of the content
+func readIndex(r io.ReaderAt, size int64) ([]indexEntry, int64, int64, error) {
+	var last [8]byte
+	if size < int64(len(last)) {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+	_, err := r.ReadAt(last[:], size-int64(len(last)))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	indexOffset := int64(binary.LittleEndian.Uint64(last[:]))
+	indexLength := size - int64(len(last)) - indexOffset
+	if indexOffset < 0 || indexLength < int64(len(indexMarker)+4+indexFooterSize-len(last)) {
+		return nil, 0, 0, fmt.Errorf("index offset %d out of the %d bytes of the file", indexOffset, size)
+	}
+
+	data := make([]byte, indexLength)
+	_, err = r.ReadAt(data, indexOffset)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	crc := data[len(data)-4:]
+	data = data[:len(data)-4]
+	if want, got := binary.LittleEndian.Uint32(crc), crc32.ChecksumIEEE(data); want != got {
+		return nil, 0, 0, IntegrityError{What: "index", Expected: want, Actual: got}
+	}
+	if !bytes.Equal(data[:len(indexMarker)], indexMarker) {
+		return nil, 0, 0, errors.New("index marker not found")
+	}
+
+	count := binary.LittleEndian.Uint32(data[len(indexMarker):])
+	entries := data[len(indexMarker)+4 : len(data)-8]
+	if uint64(len(entries)) != uint64(count)*16 {
+		return nil, 0, 0, fmt.Errorf("index of %d bytes for %d blocks", len(entries), count)
+	}
+	contentLength := binary.LittleEndian.Uint64(data[len(data)-8:])
+
+	index := make([]indexEntry, count)
+	err = binary.Read(bytes.NewReader(entries), binary.LittleEndian, index)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for i, e := range index {
+		if (i == 0 && e.ContentOffset != 0) || (i > 0 && e.ContentOffset <= index[i-1].ContentOffset) || e.ContentOffset >= contentLength {
+			return nil, 0, 0, fmt.Errorf("invalid content offset %d of block #%d", e.ContentOffset, i+1)
+		}
+		if (i > 0 && e.FrameOffset <= index[i-1].FrameOffset) || e.FrameOffset >= uint64(indexOffset) {
+			return nil, 0, 0, fmt.Errorf("invalid frame offset %d of block #%d", e.FrameOffset, i+1)
+		}
+	}
+	if count == 0 && contentLength != 0 {
+		return nil, 0, 0, fmt.Errorf("no block for a content of %d bytes", contentLength)
+	}
+
+	return index, indexOffset, int64(contentLength), nil
+}
+
+// Header yields the header of the framed file
+func (br *BlockReader) Header() Header {
+	return br.header
+}
+
+// Size yields the length of the content of the framed file
+func (br *BlockReader) Size() int64 {
+	return br.size
+}
+
+// ReadAt reads len(p) bytes of content starting at offset off, decoding the blocks covering them.
+// It is safe for concurrent use
+func (br *BlockReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < br.size {
+		i := sort.Search(len(br.index), func(i int) bool { return int64(br.index[i].ContentOffset) > off }) - 1
+		err := br.decode(i)
+		if err != nil {
+			return n, err
+		}
+
+		k := copy(p[n:], br.content.Bytes()[off-int64(br.index[i].ContentOffset):])
+		n += k
+		off += int64(k)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// decode decodes the i-th block, unless it is already decoded
+func (br *BlockReader) decode(i int) error {
+	if br.decoded == i {
+		return nil
+	}
+	br.decoded = -1
+
+	e := br.index[i]
+	contentEnd := br.size
+	if i+1 < len(br.index) {
+		contentEnd = int64(br.index[i+1].ContentOffset)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(br.r, int64(e.FrameOffset), br.indexOffset-int64(e.FrameOffset)))
+	fh, err := readFrameHeader(r)
+	if err != nil {
+		return fmt.Errorf("block #%d: %w", i+1, err)
+	}
+	if want := contentEnd - int64(e.ContentOffset); int64(fh.contentLength) != want {
+		return fmt.Errorf("block #%d: content of %d bytes in the frame header, %d in the index", i+1, fh.contentLength, want)
+	}
+
+	err = NewDecompressor().decompressBlock(r, fh, br.block, br.content)
+	if err != nil {
+		return fmt.Errorf("block #%d: %w", i+1, err)
+	}
+	br.decoded = i
+
+	return nil
+}
+
+// Read reads up to len(p) bytes of content from the current offset
+func (br *BlockReader) Read(p []byte) (int, error) {
+	if br.offset >= br.size {
+		return 0, io.EOF
+	}
+
+	n, err := br.ReadAt(p, br.offset)
+	br.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek sets the offset of the next Read, as io.Seeker does, relative to the content
+func (br *BlockReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += br.offset
+	case io.SeekEnd:
+		offset += br.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	br.offset = offset
+
+	return offset, nil
+}
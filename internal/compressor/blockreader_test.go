@@ -0,0 +1,147 @@
+package compressor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/chavacava/next/internal/table"
+)
+
+func TestBlockReaderReadAt(t *testing.T) {
+	const blockSize = 64
+	compressed := compressFramed(t, framedInput, table.NewBlended, CoderHuffman, blockSize)
+	br, err := NewBlockReader(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if br.Size() != int64(len(framedInput)) {
+		t.Fatalf("expected a content of %d bytes, got %d", len(framedInput), br.Size())
+	}
+
+	tt := map[string]struct {
+		off     int64
+		length  int
+		wantEOF bool
+	}{
+		"inside a block":      {off: 70, length: 10},
+		"across blocks":       {off: 60, length: 100},
+		"whole content":       {off: 0, length: len(framedInput)},
+		"up to the end":       {off: 250, length: 100, wantEOF: true},
+		"beyond the end":      {off: 1000, length: 10, wantEOF: true},
+		"empty at the start":  {off: 0, length: 0},
+		"last block boundary": {off: 4 * blockSize, length: 8},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				p := make([]byte, tc.length)
+				n, err := br.ReadAt(p, tc.off)
+				if tc.wantEOF != (err == io.EOF) || (err != nil && err != io.EOF) {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				want := ""
+				if tc.off < int64(len(framedInput)) {
+					want = framedInput[tc.off:]
+				}
+				if len(want) > tc.length {
+					want = want[:tc.length]
+				}
+				if got := string(p[:n]); got != want {
+					t.Fatalf("expected\n\t%q\ngot\n\t%q", want, got)
+				}
+			},
+		)
+	}
+}
+
+func TestBlockReaderSeek(t *testing.T) {
+	compressed := compressFramed(t, framedInput, table.New, CoderArithmetic, 64)
+	br, err := NewBlockReader(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	got, err := ioutil.ReadAll(br)
+	if err != nil || string(got) != framedInput {
+		t.Fatalf("expected to read the whole content, got %q, %v", got, err)
+	}
+
+	pos, err := br.Seek(-20, io.SeekEnd)
+	if err != nil || pos != int64(len(framedInput)-20) {
+		t.Fatalf("expected to seek at %d, got %d, %v", len(framedInput)-20, pos, err)
+	}
+	_, err = br.Seek(-100, io.SeekCurrent)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	p := make([]byte, 10)
+	_, err = io.ReadFull(br, p)
+	if want := framedInput[len(framedInput)-120 : len(framedInput)-110]; err != nil || string(p) != want {
+		t.Fatalf("expected %q, got %q, %v", want, p, err)
+	}
+
+	if _, err = br.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("error expected seeking before the start")
+	}
+}
+
+func TestBlockReaderDecodesOnlyReadBlocks(t *testing.T) {
+	compressed := compressFramed(t, framedInput, table.NewBlended, CoderHuffman, 64)
+	frames := frameOffsets(t, compressed)
+	compressed[frames[3]-trailerSize-1] ^= 0xff // damages the payload of the third block
+
+	br, err := NewBlockReader(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	p := make([]byte, 100)
+	if _, err := br.ReadAt(p, 10); err != nil {
+		t.Fatalf("unexpected error reading the first blocks %v", err)
+	}
+	if _, err := br.ReadAt(p[:50], 200); err != nil {
+		t.Fatalf("unexpected error reading the last blocks %v", err)
+	}
+	if _, err := br.ReadAt(p, 150); err == nil || !strings.HasPrefix(err.Error(), "block #3:") {
+		t.Fatalf("expected an error reading the damaged block, got %v", err)
+	}
+}
+
+func TestNewBlockReaderErrors(t *testing.T) {
+	framed := compressFramed(t, framedInput, table.New, CoderHuffman, 64)
+
+	stream := new(bytes.Buffer)
+	tt := table.New(strings.NewReader(framedInput), 2)
+	err := NewCompressor(tt).Compress(strings.NewReader(framedInput), stream)
+	if err != nil {
+		t.Fatalf("unexpected compression error %v", err)
+	}
+
+	damage := func(pos int) []byte {
+		result := append([]byte{}, framed...)
+		result[pos] ^= 0xff
+		return result
+	}
+
+	inputs := map[string][]byte{
+		"single stream file":   stream.Bytes(),
+		"damaged index":        damage(len(framed) - indexFooterSize - 1),
+		"damaged index offset": damage(len(framed) - 8),
+		"truncated index":      framed[:len(framed)-1],
+	}
+
+	for name, input := range inputs {
+		t.Run(name,
+			func(t *testing.T) {
+				if _, err := NewBlockReader(bytes.NewReader(input), int64(len(input))); err == nil {
+					t.Fatal("error expected")
+				}
+			},
+		)
+	}
+}
@@ -1,59 +1,325 @@
 package compressor
 
 import (
-	"errors"
+	"bufio"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"sort"
 
 	"github.com/chavacava/next/internal/bitstream"
 	"github.com/chavacava/next/internal/compressor/encoders"
+	"github.com/chavacava/next/internal/huffman"
 	"github.com/chavacava/next/internal/table"
 	"github.com/chavacava/next/internal/types"
 )
 
+// Coder identifies the entropy coder used to encode the transitions
+type Coder uint8
+
+// Coders
+const (
+	// CoderHuffman encodes the transitions of each state with a Huffman code
+	CoderHuffman = Coder(0)
+	// CoderArithmetic encodes the transitions of all the states with a single arithmetic coder
+	CoderArithmetic = Coder(1)
+	// CoderRANS encodes the transitions of all the states with a single rANS coder
+	CoderRANS = Coder(2)
+)
+
 // Compressor represents a data compressor
 // use the constructor to create new instances
 type Compressor struct {
-	tt  table.TransitionsTable
-	eds map[byte]encoders.Encoder
+	tt       table.TransitionsTable
+	coder    Coder
+	metadata Metadata
+
+	// encoders and their shared state, set for each call to Compress (see withEncoders)
+	eds        map[table.State]encoders.Encoder
+	arithmetic *encoders.ArithmeticEncoder
+	rans       *encoders.RANSEncoder
+	position   *types.Position // position of the symbol being encoded, shared by the GrowingIndex encoders
 }
 
 // NewCompressor yields a new compressor from the basis of the given
 // transition table
 func NewCompressor(tt table.TransitionsTable) Compressor {
-	result := Compressor{
-		tt:  tt,
-		eds: make(map[byte]encoders.Encoder, len(tt.Transitions)),
+	return NewCompressorWithCoder(tt, CoderHuffman)
+}
+
+// NewCompressorWithCoder yields a new compressor from the basis of the given
+// transition table that encodes transitions with the given coder
+func NewCompressorWithCoder(tt table.TransitionsTable, coder Coder) Compressor {
+	return Compressor{tt: tt, coder: coder}
+}
+
+// withEncoders yields a copy of this compressor with its own encoders of the states of the table,
+// thus copies of a compressor do not share the state of the encoders.
+// Error will arise if the encoder of a state can not be built
+func (c Compressor) withEncoders() (Compressor, error) {
+	c.eds = make(map[table.State]encoders.Encoder, len(c.tt.Transitions))
+	c.position = new(types.Position)
+	c.arithmetic, c.rans = nil, nil
+	switch c.coder {
+	case CoderArithmetic:
+		c.arithmetic = encoders.NewArithmeticEncoder()
+	case CoderRANS:
+		c.rans = encoders.NewRANSEncoder()
 	}
-	// setup endcoders
-	for s, nl := range tt.Transitions {
-		result.eds[s] = encoderFactory(*nl)
+
+	for s, nl := range c.tt.Transitions {
+		ed, err := c.encoderFactory(*nl)
+		if err != nil {
+			return Compressor{}, fmt.Errorf("unable to build the encoder of state %v: %w", []byte(s), err)
+		}
+		c.eds[s] = ed
 	}
 
-	return result
+	return c, nil
 }
 
-func encoderFactory(nl table.NextList) encoders.Encoder {
+// WithMetadata yields a copy of this compressor that stores the given metadata in the header of the compressed files.
+// The compression parameters of the metadata are set by the compressor
+func (c Compressor) WithMetadata(m Metadata) Compressor {
+	c.metadata = m
+	return c
+}
+
+func (c Compressor) encoderFactory(nl table.NextList) (encoders.Encoder, error) {
 	s := len(nl.List)
 	switch {
 	case s == 1:
-		return encoders.NewConstantFromNextList(nl)
-	default:
-		return encoders.NewHuffmanBasedFromNextList(nl)
+		return encoders.NewConstantFromNextList(nl), nil
+	case c.arithmetic != nil:
+		return encoders.NewArithmeticFromNextList(nl, c.arithmetic), nil
+	case c.rans != nil:
+		return encoders.NewRANSFromNextList(nl, c.rans), nil
+	}
+
+	huffmanBased, err := encoders.NewHuffmanBasedFromNextList(nl)
+	if err != nil {
+		return nil, err
+	}
+	if c.tt.Blended {
+		return cheapest(nl, huffmanBased, encoders.NewIndexBased(nl)), nil
 	}
+
+	// grow positions are only known for transducer tables
+	return cheapest(nl, huffmanBased, encoders.NewIndexBased(nl), encoders.NewGrowingIndex(nl, c.position)), nil
+}
+
+// cheapest yields the candidate encoder giving the shortest encoding of the transitions of the given list
+func cheapest(nl table.NextList, candidates ...encoders.Encoder) encoders.Encoder {
+	var result encoders.Encoder
+	minCost := 0
+	for _, e := range candidates {
+		c := cost(e, nl)
+		if result == nil || c < minCost {
+			result, minCost = e, c
+		}
+	}
+
+	return result
+}
+
+// payloadCoster is implemented by the encoders whose code lengths depend on the position of the transitions
+type payloadCoster interface {
+	PayloadCost() int
 }
 
-// Compress compresses the content from input and writes the result in the given writer
+// cost yields the number of bits used by the given encoder to encode the transitions of the given list:
+// the record data plus, for each transition, the length of its code times its count.
+// The encoder must encode each symbol independently of the others (prefix code)
+func cost(e encoders.Encoder, nl table.NextList) int {
+	result := e.RecordData().Len()
+	if pc, ok := e.(payloadCoster); ok {
+		return result + pc.PayloadCost()
+	}
+
+	for _, n := range nl.List {
+		code := bitstream.New()
+		e.Encode(n.S, &code)
+		result += code.Len() * int(n.Count)
+	}
+
+	return result
+}
+
+// Compress compresses the content from input and writes the result in the given writer.
+// The compressed content is written as it is encoded. If the input size can not be known
+// before reading the input (adaptive model on a non seekable input) it is only written in the trailer.
 func (c Compressor) Compress(input io.Reader, w io.Writer) error {
-	WriteHeader(w, c.tt.Root, c.tt.InputSize, byte(len(c.tt.Transitions)))
-	var p = make([]byte, 1)
-	_, err := input.Read(p)
+	c, err := c.withEncoders()
+	if err != nil {
+		return err
+	}
+
+	model := ModelTransducer
+	switch {
+	case c.tt.Adaptive:
+		model = ModelAdaptive
+	case c.tt.Blended:
+		model = ModelBlended
+	}
+
+	out := bitstream.NewWriter(w)
+	inputSize := c.tt.InputSize
+	unknownSize := false
+	if model == ModelAdaptive {
+		var known bool
+		inputSize, known = remainingSize(input)
+		unknownSize = !known
+	}
+
+	err = c.writeHeaderAndRecords(w, out, model, inputSize, unknownSize)
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	input = io.TeeReader(bufio.NewReader(input), crc)
+	switch model {
+	case ModelAdaptive:
+		var encodedSize types.Size
+		encodedSize, err = c.encodeAdaptive(input, out)
+		if err == nil && !unknownSize && encodedSize != inputSize {
+			err = fmt.Errorf("read %d bytes from the input, expected %d", encodedSize, inputSize)
+		}
+		inputSize = encodedSize
+	case ModelBlended:
+		err = c.encodeBlended(input, out)
+	default:
+		err = c.encode(input, out)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.arithmetic != nil {
+		c.arithmetic.Flush(out)
+	}
+	if c.rans != nil {
+		c.rans.Flush(out)
+	}
+
+	out.AlignToByte()
+	var trailer [trailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[:8], uint64(inputSize))
+	binary.LittleEndian.PutUint32(trailer[8:], crc.Sum32())
+	for _, b := range trailer {
+		out.WriteBits(uint64(b), 8)
+	}
+
+	return out.Flush()
+}
+
+// writeHeaderAndRecords writes the header in w then the transitions records in out
+func (c Compressor) writeHeaderAndRecords(w io.Writer, out bitstream.BitWriter, model Model, inputSize types.Size, unknownSize bool) error {
+	metadata := c.metadata
+	metadata.Parameters = &Parameters{Coder: c.coder, MaxCodeLength: huffman.DefaultMaxCodeLength}
+	err := WriteHeader(w, Header{
+		Model:       model,
+		Order:       byte(c.tt.Order),
+		Root:        []byte(c.tt.Root),
+		InputSize:   inputSize,
+		UnknownSize: unknownSize,
+		RecordCount: uint32(len(c.eds)),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	// records are written in a deterministic order
+	states := make([]table.State, 0, len(c.eds))
+	for from := range c.eds {
+		states = append(states, from)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+
+	for _, from := range states {
+		e := c.eds[from]
+		var rt recordType
+		switch e.(type) {
+		case encoders.Constant:
+			rt = 0 // constant record type
+		case encoders.HuffmanBased:
+			rt = 4 // canonical huffman record type
+		case encoders.Arithmetic:
+			rt = 2 // arithmetic record type
+		case encoders.RANS:
+			rt = 3 // rANS record type
+		case encoders.IndexBased:
+			rt = 5 // index record type
+		case encoders.GrowingIndex:
+			rt = 6 // growing index record type
+		default:
+			panic(fmt.Sprintf("unknown encoder type %t", e))
+		}
+
+		recordHeader := bitstream.BitStream{}
+		switch model {
+		case ModelBlended:
+			escape := c.tt.Transitions[from].Escape
+			if escape != nil {
+				rt |= recordFlagEscape
+			}
+			recordHeader.WriteBits(uint64(byte(rt)), 8)
+			recordHeader.WriteBits(uint64(byte(len(from))), 8)
+			for _, b := range []byte(from) {
+				recordHeader.WriteBits(uint64(b), 8)
+			}
+			if escape != nil {
+				recordHeader.WriteBits(uint64(escape.S), 8)
+			}
+		default:
+			recordHeader.WriteBits(uint64(byte(rt)), 8)
+			for _, b := range []byte(from) {
+				recordHeader.WriteBits(uint64(b), 8)
+			}
+		}
+
+		out.Append(recordHeader)
+		out.Append(e.RecordData())
+	}
+
+	return nil
+}
+
+// remainingSize yields the number of bytes left to read from the given reader, if it is seekable
+func remainingSize(r io.Reader) (types.Size, bool) {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+
+	current, err := s.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return errors.New("Unable to read the input, maybe is it empty?")
+		return 0, false
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	_, err = s.Seek(current, io.SeekStart)
+	if err != nil {
+		return 0, false
 	}
 
-	current := p[0]
-	var compressedContent = bitstream.BitStream{}
+	return types.Size(end - current), true
+}
+
+// encode encodes the input with the transducer model
+func (c Compressor) encode(input io.Reader, bs bitstream.BitWriter) error {
+	var root = make([]byte, len(c.tt.Root))
+	_, err := io.ReadFull(input, root)
+	if err != nil {
+		return fmt.Errorf("unable to read the %d bytes of the root: %v", len(root), err)
+	}
+
+	current := table.State(root)
+	var p = make([]byte, 1)
 	var pos types.Position = 0
 	for {
 		_, err := input.Read(p)
@@ -67,32 +333,95 @@ func (c Compressor) Compress(input io.Reader, w io.Writer) error {
 		next := p[0]
 
 		encoder := c.eds[current]
-		encoder.Encode(next, &compressedContent)
+		*c.position = pos
+		err = encoder.Encode(next, bs)
+		if err != nil {
+			return err
+		}
 
-		current = next
+		current = current.Next(next, c.tt.Order)
 		pos++
 	}
 
-	binaryRecords := bitstream.BitStream{}
-	for from, e := range c.eds {
-		recordHeader := bitstream.BitStream{}
-		switch e.(type) {
-		case encoders.Constant:
-			recordHeader.Append(bitstream.NewFromFullByte(0)) // add constant for 0 constant record type
-		case encoders.HuffmanBased:
-			recordHeader.Append(bitstream.NewFromFullByte(1)) // add constant for 1 huffman tree record type
-		default:
-			panic(fmt.Sprintf("unknown encoder type %t", e))
+	return nil
+}
+
+// encodeBlended encodes the input with the blended model
+func (c Compressor) encodeBlended(input io.Reader, bs bitstream.BitWriter) error {
+	var p = make([]byte, 1)
+	var pos types.Position = 0
+	context := table.State("")
+	for {
+		_, err := input.Read(p)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
 		}
 
-		recordHeader.Append(bitstream.NewFromFullByte(from))
+		next := p[0]
+		encoded := false
+		for l := len(context); l >= 0 && !encoded; l-- {
+			s := context[len(context)-l:]
+			encoder, exists := c.eds[s]
+			if !exists {
+				continue
+			}
+
+			nl := c.tt.Transitions[s]
+			if nl.Has(next) {
+				err = encoder.Encode(next, bs)
+				if err != nil {
+					return err
+				}
+				encoded = true
+				continue
+			}
 
-		binaryRecords.Append(recordHeader)
-		binaryRecords.Append(e.RecordData())
-	}
+			if nl.Escape == nil {
+				return fmt.Errorf("unable to encode symbol %v from state %v", next, []byte(s))
+			}
+			err = encoder.Encode(nl.Escape.S, bs)
+			if err != nil {
+				return err
+			}
+		}
+		if !encoded {
+			return fmt.Errorf("unable to encode symbol %v at position %v", next, pos)
+		}
 
-	binaryRecords.Append(compressedContent)
-	w.Write(binaryRecords.Bytes())
+		context = context.Next(next, c.tt.Order)
+		pos++
+	}
 
 	return nil
 }
+
+// encodeAdaptive encodes the input with the adaptive model, it yields the size of the input
+func (c Compressor) encodeAdaptive(input io.Reader, bs bitstream.BitWriter) (types.Size, error) {
+	coder := newAdaptiveCoder(c.tt.Order)
+	var p = make([]byte, 1)
+	var inputSize types.Size
+	context := table.State("")
+	for {
+		_, err := input.Read(p)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+
+		next := p[0]
+		err = coder.encode(context, next, bs)
+		if err != nil {
+			return 0, err
+		}
+
+		context = context.Next(next, c.tt.Order)
+		inputSize++
+	}
+
+	return inputSize, nil
+}
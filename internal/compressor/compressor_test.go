@@ -0,0 +1,473 @@
+package compressor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chavacava/next/internal/compressor/encoders"
+	"github.com/chavacava/next/internal/huffman"
+	"github.com/chavacava/next/internal/table"
+	"github.com/chavacava/next/internal/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	inputs := map[string]string{
+		"empty":          "",
+		"one byte":       "x",
+		"one symbol":     "aaaaaaaaaa",
+		"two bytes":      "ab",
+		"simplicity":     "Simplicity is prerequisite for reliability",
+		"repeated lines": "func main() {\n\tfmt.Println(\"hello\")\n}\nfunc main() {\n\tfmt.Println(\"world\")\n}\n",
+	}
+
+	builders := map[string]func(io.ReadSeeker, int) table.TransitionsTable{
+		"transducer": table.New,
+		"blended":    table.NewBlended,
+		"adaptive": func(_ io.ReadSeeker, order int) table.TransitionsTable {
+			return table.NewAdaptive(order)
+		},
+	}
+
+	coders := map[string]Coder{
+		"huffman":    CoderHuffman,
+		"arithmetic": CoderArithmetic,
+		"rANS":       CoderRANS,
+	}
+
+	for name, input := range inputs {
+		for model, newTable := range builders {
+			for coderName, coder := range coders {
+				for order := 1; order <= 4; order++ {
+					input, newTable, coder, order := input, newTable, coder, order
+					t.Run(fmt.Sprintf("%s %s %s order %d", name, model, coderName, order),
+						func(t *testing.T) {
+							tt := newTable(bytes.NewReader([]byte(input)), order)
+							compressed := new(bytes.Buffer)
+							err := NewCompressorWithCoder(tt, coder).Compress(bytes.NewReader([]byte(input)), compressed)
+							if err != nil {
+								t.Fatalf("unexpected compression error %v", err)
+							}
+
+							got := new(bytes.Buffer)
+							err = NewDecompressor().Decompress(compressed, got)
+							if err != nil {
+								t.Fatalf("unexpected decompression error %v", err)
+							}
+
+							if got.String() != input {
+								t.Fatalf("expected\n\t%q\ngot\n\t%q", input, got.String())
+							}
+						},
+					)
+				}
+			}
+		}
+	}
+}
+
+func TestEncoderFactory(t *testing.T) {
+	input := "Simplicity is prerequisite for reliability, reliability is prerequisite for simplicity"
+	tt := table.New(bytes.NewReader([]byte(input)), 1)
+	c, err := NewCompressor(tt).withEncoders()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for s, nl := range tt.Transitions {
+		got, err := c.encoderFactory(*nl)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(nl.List) == 1 {
+			if _, ok := got.(encoders.Constant); !ok {
+				t.Fatalf("expected a constant encoder for state %q, got %T", s, got)
+			}
+			continue
+		}
+
+		huffmanBased, err := encoders.NewHuffmanBasedFromNextList(*nl)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		candidates := []encoders.Encoder{
+			huffmanBased,
+			encoders.NewIndexBased(*nl),
+			encoders.NewGrowingIndex(*nl, new(types.Position)),
+		}
+		for _, e := range candidates {
+			if cost(got, *nl) > cost(e, *nl) {
+				t.Fatalf("for state %q expected an encoder cheaper than %T (%d bits), got %T (%d bits)", s, e, cost(e, *nl), got, cost(got, *nl))
+			}
+		}
+	}
+}
+
+// copies of a compressor do not share the state of their encoders
+func TestCompressorConcurrentUse(t *testing.T) {
+	input := []byte(strings.Repeat("Simplicity is prerequisite for reliability. ", 20))
+	for _, coder := range []Coder{CoderHuffman, CoderArithmetic, CoderRANS} {
+		c := NewCompressorWithCoder(table.New(bytes.NewReader(input), 2), coder)
+		want := new(bytes.Buffer)
+		err := c.Compress(bytes.NewReader(input), want)
+		if err != nil {
+			t.Fatalf("unexpected compression error %v", err)
+		}
+
+		got := make([]bytes.Buffer, 4)
+		errs := make([]error, len(got))
+		var wg sync.WaitGroup
+		for i := range got {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = c.Compress(bytes.NewReader(input), &got[i])
+			}(i)
+		}
+		wg.Wait()
+
+		for i := range got {
+			if errs[i] != nil || !bytes.Equal(want.Bytes(), got[i].Bytes()) {
+				t.Fatalf("expected concurrent compressions with coder %d to yield the same file, got %v", coder, errs[i])
+			}
+		}
+	}
+}
+
+func TestCompressAdaptiveNonSeekable(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":      {},
+		"one symbol": []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		"simplicity": []byte("Simplicity is prerequisite for reliability, reliability is prerequisite for simplicity"),
+	}
+
+	for name, input := range inputs {
+		input := input
+		t.Run(name,
+			func(t *testing.T) {
+				for _, seekable := range []bool{true, false} {
+					var r io.Reader = bytes.NewReader(input)
+					if !seekable {
+						r = struct{ io.Reader }{r}
+					}
+
+					compressed := new(bytes.Buffer)
+					err := NewCompressor(table.NewAdaptive(2)).Compress(r, compressed)
+					if err != nil {
+						t.Fatalf("unexpected compression error %v", err)
+					}
+
+					header, err := ReadHeader(bytes.NewReader(compressed.Bytes()))
+					if err != nil {
+						t.Fatalf("unexpected error reading the header %v", err)
+					}
+					if header.UnknownSize == seekable {
+						t.Fatalf("expected unknown size %v for a seekable (%v) input", !seekable, seekable)
+					}
+
+					got := new(bytes.Buffer)
+					err = NewDecompressor().Decompress(compressed, got)
+					if err != nil {
+						t.Fatalf("unexpected decompression error %v", err)
+					}
+					if !bytes.Equal(got.Bytes(), input) {
+						t.Fatalf("expected\n\t%q\ngot\n\t%q", input, got.Bytes())
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestEmptyAndOneByteInputs(t *testing.T) {
+	tt := map[string]struct {
+		input      string
+		newTable   func(io.ReadSeeker, int) table.TransitionsTable
+		coder      Coder
+		wantHeader Header
+	}{
+		"empty transducer": {
+			input:      "",
+			newTable:   table.New,
+			wantHeader: Header{Version: streamVersionNumber, Model: ModelTransducer, Order: 2, Root: []byte{}, InputSize: 0, RecordCount: 0},
+		},
+		"empty blended rANS": {
+			input:      "",
+			newTable:   table.NewBlended,
+			coder:      CoderRANS,
+			wantHeader: Header{Version: streamVersionNumber, Model: ModelBlended, Order: 2, Root: []byte{}, InputSize: 0, RecordCount: 0},
+		},
+		"empty adaptive": {
+			input:      "",
+			newTable:   func(_ io.ReadSeeker, order int) table.TransitionsTable { return table.NewAdaptive(order) },
+			wantHeader: Header{Version: streamVersionNumber, Model: ModelAdaptive, Order: 2, Root: []byte{}, InputSize: 0, RecordCount: 0},
+		},
+		"one byte transducer arithmetic": {
+			input:      "x",
+			newTable:   table.New,
+			coder:      CoderArithmetic,
+			wantHeader: Header{Version: streamVersionNumber, Model: ModelTransducer, Order: 2, Root: []byte("x"), InputSize: 1, RecordCount: 0},
+		},
+		"one byte blended": {
+			input:      "x",
+			newTable:   table.NewBlended,
+			wantHeader: Header{Version: streamVersionNumber, Model: ModelBlended, Order: 2, Root: []byte{}, InputSize: 1, RecordCount: 1},
+		},
+		"one byte adaptive": {
+			input:      "x",
+			newTable:   func(_ io.ReadSeeker, order int) table.TransitionsTable { return table.NewAdaptive(order) },
+			wantHeader: Header{Version: streamVersionNumber, Model: ModelAdaptive, Order: 2, Root: []byte{}, InputSize: 1, RecordCount: 0},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				compressed := new(bytes.Buffer)
+				tt := tc.newTable(strings.NewReader(tc.input), 2)
+				err := NewCompressorWithCoder(tt, tc.coder).Compress(strings.NewReader(tc.input), compressed)
+				if err != nil {
+					t.Fatalf("unexpected compression error %v", err)
+				}
+
+				header, err := ReadHeader(bytes.NewReader(compressed.Bytes()))
+				if err != nil {
+					t.Fatalf("unexpected error reading the header %v", err)
+				}
+				tc.wantHeader.Metadata.Parameters = &Parameters{Coder: tc.coder, MaxCodeLength: huffman.DefaultMaxCodeLength}
+				if !reflect.DeepEqual(tc.wantHeader, header) {
+					t.Fatalf("expected header\n\t%+v\ngot\n\t%+v", tc.wantHeader, header)
+				}
+
+				got := new(bytes.Buffer)
+				err = NewDecompressor().Decompress(compressed, got)
+				if err != nil {
+					t.Fatalf("unexpected decompression error %v", err)
+				}
+				if got.String() != tc.input {
+					t.Fatalf("expected %q, got %q", tc.input, got.String())
+				}
+			},
+		)
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	input := []byte("Simplicity is prerequisite for reliability, reliability is prerequisite for simplicity")
+	compressed := new(bytes.Buffer)
+	err := NewCompressor(table.New(bytes.NewReader(input), 1)).Compress(bytes.NewReader(input), compressed)
+	if err != nil {
+		t.Fatalf("unexpected compression error %v", err)
+	}
+
+	// position of the corrupted byte from the end of the file
+	tt := map[string]int{
+		"content CRC":     1,
+		"original length": 10,
+		"payload":         14,
+	}
+
+	for name, pos := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				corrupted := append([]byte{}, compressed.Bytes()...)
+				corrupted[len(corrupted)-pos] ^= 0x10
+
+				err := NewDecompressor().Decompress(bytes.NewReader(corrupted), new(bytes.Buffer))
+				if err == nil {
+					t.Fatal("error expected decompressing a corrupted file")
+				}
+
+				var ie IntegrityError
+				if !errors.As(err, &ie) {
+					t.Fatalf("expected an integrity error, got %v", err)
+				}
+			},
+		)
+	}
+}
+
+func TestRoundTripAllByteValues(t *testing.T) {
+	// every byte value is followed by several successors
+	input := []byte{}
+	for step := 1; step <= 3; step++ {
+		for i := 0; i < 256; i++ {
+			input = append(input, byte(i*step))
+		}
+	}
+
+	builders := map[string]func(io.ReadSeeker, int) table.TransitionsTable{
+		"transducer": table.New,
+		"blended":    table.NewBlended,
+	}
+
+	for model, newTable := range builders {
+		for _, coder := range []Coder{CoderHuffman, CoderArithmetic, CoderRANS} {
+			model, newTable, coder := model, newTable, coder
+			t.Run(fmt.Sprintf("%s coder %d", model, coder),
+				func(t *testing.T) {
+					tt := newTable(bytes.NewReader(input), 1)
+					compressed := new(bytes.Buffer)
+					err := NewCompressorWithCoder(tt, coder).Compress(bytes.NewReader(input), compressed)
+					if err != nil {
+						t.Fatalf("unexpected compression error %v", err)
+					}
+
+					header, err := ReadHeader(bytes.NewReader(compressed.Bytes()))
+					if err != nil {
+						t.Fatalf("unexpected error reading the header %v", err)
+					}
+					if model == "transducer" && header.RecordCount < 256 { // blended tables drop useless states
+						t.Fatalf("expected at least 256 records, got %d", header.RecordCount)
+					}
+
+					got := new(bytes.Buffer)
+					err = NewDecompressor().Decompress(compressed, got)
+					if err != nil {
+						t.Fatalf("unexpected decompression error %v", err)
+					}
+
+					if !bytes.Equal(got.Bytes(), input) {
+						t.Fatalf("expected\n\t%v\ngot\n\t%v", input, got.Bytes())
+					}
+				},
+			)
+		}
+	}
+}
+
+// the payload of the rANS coder is split in chunks of ransChunkSize symbols
+func TestRoundTripRANSChunks(t *testing.T) {
+	const chunkSize = 1 << 16
+	tt := map[string]struct {
+		newTable func(io.ReadSeeker, int) table.TransitionsTable
+		size     int
+	}{
+		"transducer full chunks":    {table.New, 2*chunkSize + 1}, // the root is not encoded
+		"transducer partial chunk":  {table.New, 2*chunkSize + 1000},
+		"blended with escape codes": {table.NewBlended, 2*chunkSize + 1000},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				rnd := rand.New(rand.NewSource(1))
+				input := make([]byte, tc.size)
+				for i := range input {
+					input[i] = "abcdefgh"[rnd.Intn(1+i%8)]
+				}
+
+				tt := tc.newTable(bytes.NewReader(input), 1)
+				compressed := new(bytes.Buffer)
+				err := NewCompressorWithCoder(tt, CoderRANS).Compress(bytes.NewReader(input), compressed)
+				if err != nil {
+					t.Fatalf("unexpected compression error %v", err)
+				}
+
+				got := new(bytes.Buffer)
+				err = NewDecompressor().Decompress(compressed, got)
+				if err != nil {
+					t.Fatalf("unexpected decompression error %v", err)
+				}
+				if !bytes.Equal(got.Bytes(), input) {
+					t.Fatal("decompressed content differs from the input")
+				}
+			},
+		)
+	}
+}
+
+// golden files are compressions of testdata/simplicity.txt by each version of the format
+var goldenFiles = map[string]struct {
+	version  uint8
+	metadata Metadata
+}{
+	"testdata/simplicity.v0.nxt":          {version: 0},                           // order 1
+	"testdata/simplicity.v1.nxt":          {version: 1},                           // order 2
+	"testdata/simplicity.v2.nxt":          {version: 2},                           // blended order 2
+	"testdata/simplicity.v3.nxt":          {version: 3},                           // blended order 2
+	"testdata/simplicity.v4.nxt":          {version: 4},                           // blended order 2, without extensions
+	"testdata/simplicity.v4.metadata.nxt": {version: 4, metadata: goldenMetadata}, // blended order 2
+}
+
+// currentGoldenFile is the golden file written by the current version of the compressor
+const currentGoldenFile = "testdata/simplicity.v4.metadata.nxt"
+
+var goldenMetadata = Metadata{
+	Name:       "simplicity.txt",
+	ModTime:    time.Unix(0, 1600000000123456789),
+	Mode:       0644,
+	Comment:    "golden file",
+	Parameters: &Parameters{Coder: CoderHuffman, MaxCodeLength: 15},
+}
+
+func TestDecompressGoldenFiles(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/simplicity.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, golden := range goldenFiles {
+		file, golden := file, golden
+		t.Run(file,
+			func(t *testing.T) {
+				compressed, err := ioutil.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				header, err := ReadHeader(bytes.NewReader(compressed))
+				if err != nil {
+					t.Fatalf("unexpected error reading the header %v", err)
+				}
+				if header.Version != golden.version {
+					t.Fatalf("expected version %d, got %d", golden.version, header.Version)
+				}
+				if !reflect.DeepEqual(header.Metadata, golden.metadata) {
+					t.Fatalf("expected metadata\n\t%+v\ngot\n\t%+v", golden.metadata, header.Metadata)
+				}
+
+				got := new(bytes.Buffer)
+				err = NewDecompressor().Decompress(bytes.NewReader(compressed), got)
+				if err != nil {
+					t.Fatalf("unexpected decompression error %v", err)
+				}
+
+				if !bytes.Equal(got.Bytes(), want) {
+					t.Fatalf("expected\n\t%q\ngot\n\t%q", want, got.Bytes())
+				}
+			},
+		)
+	}
+}
+
+func TestCompressGoldenFile(t *testing.T) {
+	input, err := ioutil.ReadFile("testdata/simplicity.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := ioutil.ReadFile(currentGoldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(bytes.Buffer)
+	cx := NewCompressor(table.NewBlended(bytes.NewReader(input), 2)).WithMetadata(goldenMetadata)
+	err = cx.Compress(bytes.NewReader(input), got)
+	if err != nil {
+		t.Fatalf("unexpected compression error %v", err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("expected\n\t%v\ngot\n\t%v", want, got.Bytes())
+	}
+}
@@ -1,19 +1,27 @@
 package compressor
 
 import (
+	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 
 	"github.com/chavacava/next/internal/bitstream"
 	"github.com/chavacava/next/internal/compressor/encoders"
 	"github.com/chavacava/next/internal/huffman"
+	"github.com/chavacava/next/internal/table"
 	"github.com/chavacava/next/internal/types"
 )
 
+// maxRecordsHint is the largest number of records of a header used to preallocate the decoders
+const maxRecordsHint = 1 << 16
+
 // Decompressor represents a decompressor for data compressed by the Compressor
 // Use the constructor to create new instances
 type Decompressor struct {
-	decoders map[byte]encoders.Decoder
+	decoders map[table.State]encoders.Decoder
 }
 
 // NewDecompressor yields a new decompressor
@@ -21,38 +29,133 @@ func NewDecompressor() Decompressor {
 	return Decompressor{}
 }
 
-// Decompress decompresses the data it reads from the given reader and writes the result in the given writer
+// Decompress decompresses the data it reads from the given reader and writes the result in the given writer.
+// The content of a framed file is written block by block, once the integrity of each block is checked
 func (d Decompressor) Decompress(r io.Reader, w io.Writer) error {
-	rootSymbol, inputSize, symbolCount, err := ReadHeader(r)
+	header, err := ReadHeader(r)
 	if err != nil {
 		return fmt.Errorf("error while reading the file header: %v", err)
 	}
 
-	bs := bitstream.New()
-	for {
-		b := make([]byte, 1)
-		_, err := r.Read(b)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+	if header.BlockSize > 0 {
+		return d.decompressFrames(r, w, false)
+	}
 
-			return err
-		}
-		bs.Append(bitstream.NewFromFullByte(b[0]))
+	return d.decompressStream(header, r, w)
+}
+
+// Recover decompresses the data it reads from the given reader, as Decompress does, but damaged blocks of
+// framed files are skipped instead of stopping the decompression.
+// Error will be a RecoveryError if some blocks are skipped
+func (d Decompressor) Recover(r io.Reader, w io.Writer) error {
+	header, err := ReadHeader(r)
+	if err != nil {
+		return fmt.Errorf("error while reading the file header: %v", err)
+	}
+
+	if header.BlockSize > 0 {
+		return d.decompressFrames(r, w, true)
+	}
+
+	return d.decompressStream(header, r, w)
+}
+
+// decompressStream decompresses the records and payload of a single stream file of the given header.
+// The header being valid, errors decoding the records or the payload are integrity errors,
+// unless they come from reading r or writing in w
+func (d Decompressor) decompressStream(header Header, r io.Reader, w io.Writer) error {
+	er := &errReader{r: r}
+	ew := &errWriter{w: w}
+	err := d.decodeStream(header, er, ew)
+
+	var ie IntegrityError
+	if err == nil || errors.As(err, &ie) || er.err != nil || ew.err != nil {
+		return err
+	}
+
+	return IntegrityError{What: "content", Err: err}
+}
+
+// errReader records the first error, but io.EOF, of reading the underlying reader
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF && er.err == nil {
+		er.err = err
+	}
+
+	return n, err
+}
+
+// errWriter records the first error of writing in the underlying writer
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	n, err := ew.w.Write(p)
+	if err != nil && ew.err == nil {
+		ew.err = err
 	}
 
-	bsp := &bs
+	return n, err
+}
+
+// decodeStream decodes the records and payload of a single stream file of the given header
+func (d Decompressor) decodeStream(header Header, r io.Reader, w io.Writer) error {
+	var err error
+	var tr *trailerReader
+	if header.Version >= 4 {
+		tr = newTrailerReader(r, trailerSize)
+		r = tr
+	}
+	bs := bitstream.NewReader(r)
 	// setup decoders
-	decoders := make(map[byte]encoders.Decoder, symbolCount)
-	for i := byte(0); i < symbolCount; i++ {
-		recordType, err := bs.ReadByte()
+	hint := header.RecordCount // not trusted to allocate memory
+	if hint > maxRecordsHint {
+		hint = maxRecordsHint
+	}
+	decoders := make(map[table.State]encoders.Decoder, hint)
+	escapes := map[table.State]byte{}
+	var arithmetic *encoders.ArithmeticDecoder
+	var rans *encoders.RANSDecoder
+	position := new(types.Position) // position of the symbol being decoded, shared by the GrowingIndex decoders
+	bitRecords := false             // records whose codes are bit sequences
+	for i := uint32(0); i < header.RecordCount; i++ {
+		rt, err := bs.ReadByte()
 		if err != nil {
 			return err
 		}
-		from, err := bs.ReadByte()
-		if err != nil {
-			return err
+		recordType := recordType(rt)
+
+		stateLength := header.Order
+		if header.Model == ModelBlended {
+			stateLength, err = bs.ReadByte()
+			if err != nil {
+				return err
+			}
+		}
+
+		from := make([]byte, stateLength)
+		for j := range from {
+			from[j], err = bs.ReadByte()
+			if err != nil {
+				return err
+			}
+		}
+		state := table.State(from)
+
+		if header.Model == ModelBlended && recordType&recordFlagEscape != 0 {
+			escapes[state], err = bs.ReadByte()
+			if err != nil {
+				return err
+			}
+			recordType &^= recordFlagEscape
 		}
 
 		switch recordType {
@@ -61,36 +164,264 @@ func (d Decompressor) Decompress(r io.Reader, w io.Writer) error {
 			if err != nil {
 				return err
 			}
-			decoders[from] = encoders.NewConstant(to)
+			decoders[state] = encoders.NewConstant(to)
 		case 1: // huffman tree
-			tree := huffman.NewTreeFromBS(bsp)
-			decoders[from] = encoders.NewHuffmanBased(tree)
+			tree, err := huffman.NewTreeFromBS(bs)
+			if err != nil {
+				return err
+			}
+			decoders[state] = encoders.NewHuffmanBased(tree)
+			bitRecords = true
+		case 4: // canonical huffman
+			tree, err := huffman.NewCanonicalTreeFromBS(bs)
+			if err != nil {
+				return err
+			}
+			decoders[state] = encoders.NewHuffmanBased(tree)
+			bitRecords = true
+		case 5: // index
+			decoders[state], err = encoders.NewIndexBasedFromBS(bs)
+			if err != nil {
+				return err
+			}
+			bitRecords = true
+		case 6: // growing index
+			decoders[state], err = encoders.NewGrowingIndexFromBS(bs, position)
+			if err != nil {
+				return err
+			}
+			bitRecords = true
+		case 2: // arithmetic
+			if arithmetic == nil {
+				arithmetic = encoders.NewArithmeticDecoder()
+			}
+			decoders[state], err = encoders.NewArithmeticFromBS(bs, arithmetic)
+			if err != nil {
+				return err
+			}
+		case 3: // rANS
+			if rans == nil {
+				rans = encoders.NewRANSDecoder()
+			}
+			decoders[state], err = encoders.NewRANSFromBS(bs, rans)
+			if err != nil {
+				return err
+			}
 		default:
-			panic(fmt.Sprintf("unknown record type %v decoding %v th symbol", recordType, i+1))
+			return fmt.Errorf("unknown record type %v decoding %v th state", recordType, i+1)
 		}
 	}
 
-	current := rootSymbol
-	w.Write([]byte{current})
-	generatedSymbolCount := types.Size(1)
-	for {
-		if generatedSymbolCount == inputSize {
-			break
+	if (bitRecords && arithmetic != nil) || (bitRecords && rans != nil) || (arithmetic != nil && rans != nil) {
+		return errors.New("huffman (or index), arithmetic and rANS records can not be mixed in the same file")
+	}
+
+	crc := crc32.NewIEEE()
+	out := bufio.NewWriter(io.MultiWriter(w, crc))
+	end := func(generated types.Size) bool { return generated == header.InputSize }
+	if header.UnknownSize {
+		end = func(generated types.Size) bool {
+			// the payload ends less than 64 bits after the last symbol,
+			// thus the trailer holding the original length is read if there are less than 64 bits to read
+			if _, available := bs.Peek(64); available == 64 {
+				return false
+			}
+			trailer, ok := tr.trailer()
+			return !ok || generated >= types.Size(binary.LittleEndian.Uint64(trailer))
+		}
+	}
+
+	switch header.Model {
+	case ModelAdaptive:
+		err = decodeAdaptive(header, end, bs, out)
+	case ModelBlended:
+		err = decodeBlended(header, end, decoders, escapes, bs, out)
+	default:
+		err = decode(header, end, decoders, position, bs, out)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = out.Flush()
+	if err != nil {
+		return err
+	}
+
+	switch header.Version {
+	case 0, 1, 2:
+		return nil
+	case 3:
+		return checkCRC(bs, crc.Sum32())
+	default:
+		return checkTrailer(header, bs, tr, crc.Sum32())
+	}
+}
+
+// checkCRC reads the CRC following the payload of version 3 files and checks the CRC of the decoded content
+func checkCRC(bs *bitstream.Reader, crc uint32) error {
+	err := bs.AlignToByte()
+	if err != nil {
+		return err
+	}
+
+	var trailer [4]byte
+	for i := range trailer {
+		trailer[i], err = bs.ReadByte()
+		if err != nil {
+			return fmt.Errorf("unable to read the trailer: %v", err)
 		}
+	}
+
+	if want := binary.LittleEndian.Uint32(trailer[:]); crc != want {
+		return IntegrityError{What: "content", Expected: want, Actual: crc}
+	}
+
+	return nil
+}
+
+// checkTrailer checks that the whole payload is decoded and checks the original length and the CRC
+// of the decoded content against the trailer
+func checkTrailer(header Header, bs *bitstream.Reader, tr *trailerReader, crc uint32) error {
+	err := bs.AlignToByte()
+	if err != nil {
+		return err
+	}
+	if _, available := bs.Peek(1); available != 0 {
+		return errors.New("payload longer than the encoded content")
+	}
+
+	trailer, ok := tr.trailer()
+	if !ok {
+		return fmt.Errorf("unable to read the trailer: %v", io.ErrUnexpectedEOF)
+	}
+
+	if size := types.Size(binary.LittleEndian.Uint64(trailer)); !header.UnknownSize && size != header.InputSize {
+		return fmt.Errorf("original length %d in the header, %d in the trailer", header.InputSize, size)
+	}
+
+	if want := binary.LittleEndian.Uint32(trailer[8:]); crc != want {
+		return IntegrityError{What: "content", Expected: want, Actual: crc}
+	}
+
+	return nil
+}
+
+// trailerReader reads all the bytes of the underlying reader but the last n ones, the trailer.
+// Use the constructor to create new instances
+type trailerReader struct {
+	r     io.Reader
+	n     int
+	buf   []byte // read bytes not yet returned, including the trailer candidate
+	chunk []byte
+	err   error // first error (or io.EOF) while reading from r
+}
+
+func newTrailerReader(r io.Reader, n int) *trailerReader {
+	return &trailerReader{r: r, n: n, chunk: make([]byte, 32*1024)}
+}
+
+func (tr *trailerReader) Read(p []byte) (int, error) {
+	for len(tr.buf) <= tr.n && tr.err == nil {
+		var k int
+		k, tr.err = tr.r.Read(tr.chunk)
+		tr.buf = append(tr.buf, tr.chunk[:k]...)
+	}
+
+	if len(tr.buf) <= tr.n {
+		return 0, tr.err
+	}
+
+	k := copy(p, tr.buf[:len(tr.buf)-tr.n])
+	tr.buf = append(tr.buf[:0], tr.buf[k:]...)
+
+	return k, nil
+}
+
+// trailer yields the trailer, if the underlying reader is read up to its end
+func (tr *trailerReader) trailer() ([]byte, bool) {
+	if tr.err != io.EOF || len(tr.buf) != tr.n {
+		return nil, false
+	}
+
+	return tr.buf, true
+}
 
+// decode decodes the payload of the transducer model, position is updated with the position of the symbol being decoded.
+// end tells whether the given number of decoded symbols is the original length
+func decode(header Header, end func(types.Size) bool, decoders map[table.State]encoders.Decoder, position *types.Position, bs bitstream.BitReader, w io.Writer) error {
+	order := int(header.Order)
+	current := table.State(header.Root)
+	w.Write(header.Root)
+	generatedSymbolCount := types.Size(len(header.Root))
+	for !end(generatedSymbolCount) {
 		decoder, exists := decoders[current]
 		if !exists {
-			return fmt.Errorf("no decoder for symbol %v (when generating symbol #%v)", current, generatedSymbolCount)
+			return fmt.Errorf("no decoder for state %v (when generating symbol #%v)", []byte(current), generatedSymbolCount)
 		}
-		var next byte
-		next, err = decoder.Decode(&bs)
+		*position = types.Position(generatedSymbolCount) - types.Position(len(header.Root))
+		next, err := decoder.Decode(bs)
 		if err != nil {
 			return err
 		}
 		w.Write([]byte{next})
-		current = next
+		current = current.Next(next, order)
+		generatedSymbolCount++
+	}
+
+	return nil
+}
+
+// decodeBlended decodes the payload of the blended model
+func decodeBlended(header Header, end func(types.Size) bool, decoders map[table.State]encoders.Decoder, escapes map[table.State]byte, bs bitstream.BitReader, w io.Writer) error {
+	order := int(header.Order)
+	context := table.State("")
+	generatedSymbolCount := types.Size(0)
+	for !end(generatedSymbolCount) {
+		decoded := false
+		var next byte
+		for l := len(context); l >= 0 && !decoded; l-- {
+			s := context[len(context)-l:]
+			decoder, exists := decoders[s]
+			if !exists {
+				continue
+			}
+
+			var err error
+			next, err = decoder.Decode(bs)
+			if err != nil {
+				return err
+			}
+
+			escape, hasEscape := escapes[s]
+			decoded = !hasEscape || next != escape
+		}
+		if !decoded {
+			return fmt.Errorf("no decoder for context %v (when generating symbol #%v)", []byte(context), generatedSymbolCount)
+		}
+
+		w.Write([]byte{next})
+		context = context.Next(next, order)
 		generatedSymbolCount++
 	}
 
 	return nil
 }
+
+// decodeAdaptive decodes the payload of the adaptive model
+func decodeAdaptive(header Header, end func(types.Size) bool, bs bitstream.BitReader, w io.Writer) error {
+	order := int(header.Order)
+	coder := newAdaptiveCoder(order)
+	context := table.State("")
+	for generatedSymbolCount := types.Size(0); !end(generatedSymbolCount); generatedSymbolCount++ {
+		next, err := coder.decode(context, bs)
+		if err != nil {
+			return err
+		}
+
+		w.Write([]byte{next})
+		context = context.Next(next, order)
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package encoders
+
+import (
+	"fmt"
+
+	"github.com/chavacava/next/internal/bitstream"
+	"github.com/chavacava/next/internal/table"
+)
+
+// Precision of the arithmetic coder
+const (
+	codeBits     = 32
+	topValue     = uint64(1)<<codeBits - 1
+	firstQuarter = topValue/4 + 1
+	half         = 2 * firstQuarter
+	thirdQuarter = 3 * firstQuarter
+)
+
+// Frequencies of the symbols are scaled to fit in freqBits bits,
+// the actual bit width of the frequencies of a record is stored in freqWidthBits bits
+const (
+	freqBits      = 12
+	maxFreq       = 1<<freqBits - 1
+	freqWidthBits = 4
+)
+
+// ArithmeticEncoder is the state of an arithmetic encoder shared by all the Arithmetic encoders of a file.
+// Once all symbols are encoded, the encoder must be flushed.
+// Use the constructor to create new instances
+type ArithmeticEncoder struct {
+	low, high uint64
+	pending   int
+	started   bool
+}
+
+// NewArithmeticEncoder yields a new arithmetic encoder
+func NewArithmeticEncoder() *ArithmeticEncoder {
+	return &ArithmeticEncoder{low: 0, high: topValue}
+}
+
+func (ae *ArithmeticEncoder) encode(cumLow, cumHigh, total uint64, bs bitstream.BitWriter) {
+	ae.started = true
+	r := ae.high - ae.low + 1
+	ae.high = ae.low + r*cumHigh/total - 1
+	ae.low = ae.low + r*cumLow/total
+
+	for {
+		switch {
+		case ae.high < half:
+			ae.emit(false, bs)
+		case ae.low >= half:
+			ae.emit(true, bs)
+			ae.low -= half
+			ae.high -= half
+		case ae.low >= firstQuarter && ae.high < thirdQuarter:
+			ae.pending++
+			ae.low -= firstQuarter
+			ae.high -= firstQuarter
+		default:
+			return
+		}
+
+		ae.low = 2 * ae.low
+		ae.high = 2*ae.high + 1
+	}
+}
+
+// emit appends the given bit followed by the pending ones (opposite of the given bit)
+func (ae *ArithmeticEncoder) emit(bit bool, bs bitstream.BitWriter) {
+	v := uint64(0)
+	if bit {
+		v = 1
+	}
+	bs.WriteBits(v, 1)
+	for ; ae.pending > 0; ae.pending-- {
+		bs.WriteBits(v^1, 1)
+	}
+}
+
+// Flush appends to the given bitstream the bits required to decode all the symbols encoded so far
+// and resets the encoder.
+// The bits are padded with the zeros the decoder reads ahead, thus the decoder stops at the end of the bits.
+// Nothing is appended if no symbol was encoded
+func (ae *ArithmeticEncoder) Flush(bs bitstream.BitWriter) {
+	if !ae.started {
+		return
+	}
+
+	ae.pending++
+	ae.emit(ae.low >= firstQuarter, bs)
+	bs.WriteBits(0, codeBits-2)
+	ae.low, ae.high, ae.started = 0, topValue, false
+}
+
+// ArithmeticDecoder is the state of an arithmetic decoder shared by all the Arithmetic decoders of a file.
+// Use the constructor to create new instances
+type ArithmeticDecoder struct {
+	low, high, value uint64
+	started          bool
+}
+
+// NewArithmeticDecoder yields a new arithmetic decoder
+func NewArithmeticDecoder() *ArithmeticDecoder {
+	return &ArithmeticDecoder{low: 0, high: topValue}
+}
+
+// nextBit yields the next bit of the bitstream, bits beyond the end of the bitstream are zeros
+func nextBit(bs bitstream.BitReader) uint64 {
+	b, err := bs.Read()
+	if err != nil || !b {
+		return 0
+	}
+
+	return 1
+}
+
+func (ad *ArithmeticDecoder) decode(cum []uint64, bs bitstream.BitReader) int {
+	if !ad.started {
+		for i := 0; i < codeBits; i++ {
+			ad.value = 2*ad.value + nextBit(bs)
+		}
+		ad.started = true
+	}
+
+	total := cum[len(cum)-1]
+	r := ad.high - ad.low + 1
+	count := ((ad.value-ad.low+1)*total - 1) / r
+	idx := 0
+	for cum[idx+1] <= count {
+		idx++
+	}
+
+	ad.high = ad.low + r*cum[idx+1]/total - 1
+	ad.low = ad.low + r*cum[idx]/total
+
+	for {
+		switch {
+		case ad.high < half:
+		case ad.low >= half:
+			ad.value -= half
+			ad.low -= half
+			ad.high -= half
+		case ad.low >= firstQuarter && ad.high < thirdQuarter:
+			ad.value -= firstQuarter
+			ad.low -= firstQuarter
+			ad.high -= firstQuarter
+		default:
+			return idx
+		}
+
+		ad.low = 2 * ad.low
+		ad.high = 2*ad.high + 1
+		ad.value = 2*ad.value + nextBit(bs)
+	}
+}
+
+// Arithmetic encodes (decodes) the transitions of a state with an arithmetic coder shared by all the states
+type Arithmetic struct {
+	symbols []byte
+	freqs   []uint64
+	cum     []uint64 // cum[i] is the sum of the frequencies of symbols before symbols[i]
+	encoder *ArithmeticEncoder
+	decoder *ArithmeticDecoder
+}
+
+func newArithmetic(symbols []byte, freqs []uint64) Arithmetic {
+	cum := make([]uint64, len(freqs)+1)
+	for i, f := range freqs {
+		cum[i+1] = cum[i] + f
+	}
+
+	return Arithmetic{symbols: symbols, freqs: freqs, cum: cum}
+}
+
+// NewArithmeticFromNextList yields an arithmetic encoder for the given next list, using the given shared encoder.
+// The counts of the list are scaled to fit in the record data
+func NewArithmeticFromNextList(nl table.NextList, encoder *ArithmeticEncoder) Arithmetic {
+	if len(nl.List) > 256 {
+		panic(fmt.Sprintf("%v elements can not be encoded in an arithmetic record", len(nl.List)))
+	}
+
+	maxCount := nl.List[0].Count
+	for _, n := range nl.List {
+		if n.Count > maxCount {
+			maxCount = n.Count
+		}
+	}
+
+	symbols := make([]byte, len(nl.List))
+	freqs := make([]uint64, len(nl.List))
+	for i, n := range nl.List {
+		symbols[i] = n.S
+		freqs[i] = uint64(n.Count)
+		if maxCount > maxFreq {
+			freqs[i] = uint64(float64(n.Count) * maxFreq / float64(maxCount))
+		}
+		if freqs[i] == 0 {
+			freqs[i] = 1
+		}
+	}
+
+	result := newArithmetic(symbols, freqs)
+	result.encoder = encoder
+
+	return result
+}
+
+// NewArithmeticFromBS yields an arithmetic decoder from its record data in the given bitstream, using the given shared decoder
+func NewArithmeticFromBS(bs bitstream.BitReader, decoder *ArithmeticDecoder) (Arithmetic, error) {
+	n, err := bs.ReadByte()
+	if err != nil {
+		return Arithmetic{}, err
+	}
+
+	count := int(n) + 1
+	width, err := bs.ReadBits(freqWidthBits)
+	if err != nil {
+		return Arithmetic{}, err
+	}
+	width++
+
+	symbols := make([]byte, count)
+	freqs := make([]uint64, count)
+	for i := 0; i < count; i++ {
+		symbols[i], err = bs.ReadByte()
+		if err != nil {
+			return Arithmetic{}, err
+		}
+		freqs[i], err = bs.ReadBits(uint(width))
+		if err != nil {
+			return Arithmetic{}, err
+		}
+		if freqs[i] == 0 {
+			return Arithmetic{}, fmt.Errorf("null frequency for symbol %v", symbols[i])
+		}
+	}
+
+	result := newArithmetic(symbols, freqs)
+	result.decoder = decoder
+
+	return result, nil
+}
+
+// RecordData yields the symbols and their frequencies:
+// the number of symbols minus one (8 bits), the bit width w of frequencies minus one (4 bits)
+// followed by each symbol (8 bits) and its frequency (w bits)
+func (ed Arithmetic) RecordData() bitstream.BitStream {
+	width := 1
+	for _, f := range ed.freqs {
+		if w := bitWidth(f); w > width {
+			width = w
+		}
+	}
+
+	result := bitstream.NewFromFullByte(byte(len(ed.symbols) - 1))
+	result.WriteBits(uint64(width-1), freqWidthBits)
+	for i, s := range ed.symbols {
+		result.WriteBits(uint64(s), 8)
+		result.WriteBits(ed.freqs[i], uint(width))
+	}
+
+	return result
+}
+
+func (ed Arithmetic) Encode(to byte, bs bitstream.BitWriter) error {
+	for i, s := range ed.symbols {
+		if s == to {
+			ed.encoder.encode(ed.cum[i], ed.cum[i+1], ed.cum[len(ed.cum)-1], bs)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown symbol %v in arithmetic encoder", to)
+}
+
+func (ed Arithmetic) Decode(bs bitstream.BitReader) (byte, error) {
+	return ed.symbols[ed.decoder.decode(ed.cum, bs)], nil
+}
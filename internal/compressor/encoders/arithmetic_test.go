@@ -0,0 +1,134 @@
+package encoders
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chavacava/next/internal/bitstream"
+	"github.com/chavacava/next/internal/table"
+)
+
+// nextList yields the list of successors having the given counts
+func nextList(counts map[byte]int) table.NextList {
+	tt := table.NewAdaptive(1)
+	for s, count := range counts {
+		for i := 0; i < count; i++ {
+			tt.Update(table.State(""), s, func(table.State, *table.NextList) {})
+		}
+	}
+
+	return *tt.Transitions[table.State("")]
+}
+
+// testLists are the successors lists of the tests of the arithmetic and rANS records
+var testLists = map[string]map[byte]int{
+	"one symbol":   {65: 3},
+	"two symbols":  {65: 3, 66: 1},
+	"256 symbols":  allSymbols(),
+	"very skewed":  {65: 1000000, 66: 1, 67: 2, 68: 1},
+	"large counts": {0: 100000, 255: 70000, 128: 5000},
+}
+
+// allSymbols yields counts of the 256 byte values, from 1 to 256
+func allSymbols() map[byte]int {
+	result := map[byte]int{}
+	for i := 0; i < 256; i++ {
+		result[byte(i)] = i + 1
+	}
+
+	return result
+}
+
+// record yields the data of an arithmetic or rANS record of the given symbols and frequencies
+func record(width uint, symbols []byte, freqs []uint64) bitstream.BitStream {
+	result := bitstream.NewFromFullByte(byte(len(symbols) - 1))
+	result.WriteBits(uint64(width-1), freqWidthBits)
+	for i, s := range symbols {
+		result.WriteBits(uint64(s), 8)
+		if i < len(freqs) {
+			result.WriteBits(freqs[i], width)
+		}
+	}
+
+	return result
+}
+
+func TestArithmeticQuantization(t *testing.T) {
+	for name, counts := range testLists {
+		counts := counts
+		t.Run(name,
+			func(t *testing.T) {
+				ed := NewArithmeticFromNextList(nextList(counts), NewArithmeticEncoder())
+				maxCount := 0
+				for _, c := range counts {
+					if c > maxCount {
+						maxCount = c
+					}
+				}
+				if len(ed.symbols) != len(counts) {
+					t.Fatalf("expected %d symbols, got %d", len(counts), len(ed.symbols))
+				}
+				for i, s := range ed.symbols {
+					if f := ed.freqs[i]; f < 1 || f > maxFreq {
+						t.Fatalf("frequency %v of symbol %v out of [1,%v]", f, s, maxFreq)
+					}
+					// counts are only scaled if one of them does not fit in the record
+					if maxCount <= maxFreq && ed.freqs[i] != uint64(counts[s]) {
+						t.Fatalf("expected the frequency of symbol %v to be its count %v, got %v", s, counts[s], ed.freqs[i])
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestArithmeticRecordDataRoundTrip(t *testing.T) {
+	for name, counts := range testLists {
+		counts := counts
+		t.Run(name,
+			func(t *testing.T) {
+				ed := NewArithmeticFromNextList(nextList(counts), NewArithmeticEncoder())
+				record := ed.RecordData()
+				got, err := NewArithmeticFromBS(&record, NewArithmeticDecoder())
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if record.Remaining() != 0 {
+					t.Fatalf("expected the whole record to be read, %d bits left", record.Remaining())
+				}
+				if !reflect.DeepEqual(ed.symbols, got.symbols) || !reflect.DeepEqual(ed.freqs, got.freqs) {
+					t.Fatalf("expected\n\t%v %v\ngot\n\t%v %v", ed.symbols, ed.freqs, got.symbols, got.freqs)
+				}
+			},
+		)
+	}
+}
+
+func TestNewArithmeticFromBSErrors(t *testing.T) {
+	tt := map[string]struct {
+		record  bitstream.BitStream
+		wantErr string
+	}{
+		"null frequency": {
+			record:  record(12, []byte{65, 66}, []uint64{10, 0}),
+			wantErr: "null frequency for symbol 66",
+		},
+		"truncated record": {
+			record:  record(12, []byte{65, 66}, []uint64{10}),
+			wantErr: "",
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name,
+			func(t *testing.T) {
+				_, err := NewArithmeticFromBS(&tc.record, NewArithmeticDecoder())
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+			},
+		)
+	}
+}
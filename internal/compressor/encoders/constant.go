@@ -21,10 +21,10 @@ func (ed Constant) RecordData() bitstream.BitStream {
 	return bitstream.NewFromByte(ed.s, 8)
 }
 
-func (ed Constant) Encode(to byte, bs *bitstream.BitStream) error {
+func (ed Constant) Encode(to byte, bs bitstream.BitWriter) error {
 	return nil
 }
 
-func (ed Constant) Decode(bs *bitstream.BitStream) (byte, error) {
+func (ed Constant) Decode(bs bitstream.BitReader) (byte, error) {
 	return ed.s, nil
 }
@@ -5,9 +5,19 @@ import (
 )
 
 type Encoder interface {
-	Encode(to byte, bs *bitstream.BitStream) error
+	Encode(to byte, bs bitstream.BitWriter) error
 	RecordData() bitstream.BitStream
 }
 type Decoder interface {
-	Decode(bs *bitstream.BitStream) (byte, error)
+	Decode(bs bitstream.BitReader) (byte, error)
+}
+
+// bitWidth yields the number of bits required to write v (at least 1)
+func bitWidth(v uint64) int {
+	result := 1
+	for v >= 1<<uint(result) {
+		result++
+	}
+
+	return result
 }
@@ -1,44 +1,135 @@
 package encoders
 
 import (
-	"errors"
-	"io"
+	"fmt"
 
 	"github.com/chavacava/next/internal/bitstream"
 	"github.com/chavacava/next/internal/table"
 	"github.com/chavacava/next/internal/types"
 )
 
+// growsWidthBits is the number of bits of the field holding the bit width of grow positions (minus one)
+const growsWidthBits = 6
+
+// GrowingIndex encodes the transitions of a state with the index of the successor in the list of successors,
+// successors being listed in order of first occurrence.
+// The number of bits of the index grows with the position of the transition in the input:
+// it is one plus the number of grow positions before the transition.
+// The position of the transition being encoded (decoded) is shared by all the GrowingIndex of a file
 type GrowingIndex struct {
 	IndexBased
-	grows []types.Position
+	grows       []types.Position
+	pos         *types.Position
+	payloadCost int
 }
 
-func NewGrowingIndex(nl table.NextList) GrowingIndex {
+// NewGrowingIndex yields a growing index encoder for the given next list of a transducer table,
+// pos is the position of the transitions to encode
+func NewGrowingIndex(nl table.NextList, pos *types.Position) GrowingIndex {
 	result := GrowingIndex{
-		NewIndexBased(nl),
-		nl.Grows,
+		IndexBased: NewIndexBased(nl),
+		grows:      nl.Grows,
+		pos:        pos,
+	}
+
+	ends := append(append([]types.SymbolCountType{}, nl.GrowTotals...), nl.Total())
+	start := types.SymbolCountType(0)
+	for i, end := range ends {
+		width := minByte(result.idxSize, byte(i+1))
+		result.payloadCost += int(end-start) * int(width)
+		start = end
+	}
+
+	return result
+}
+
+// NewGrowingIndexFromBS yields a growing index decoder from its record data in the given bitstream,
+// pos is the position of the transitions to decode
+func NewGrowingIndexFromBS(bs bitstream.BitReader, pos *types.Position) (GrowingIndex, error) {
+	ib, err := NewIndexBasedFromBS(bs)
+	if err != nil {
+		return GrowingIndex{}, err
+	}
+
+	count, err := bs.ReadBits(3)
+	if err != nil {
+		return GrowingIndex{}, err
+	}
+
+	width, err := bs.ReadBits(growsWidthBits)
+	if err != nil {
+		return GrowingIndex{}, err
+	}
+	width++
+
+	grows := make([]types.Position, count)
+	for i := range grows {
+		p, err := bs.ReadBits(uint(width))
+		if err != nil {
+			return GrowingIndex{}, err
+		}
+		grows[i] = types.Position(p)
+		if i > 0 && grows[i] <= grows[i-1] {
+			return GrowingIndex{}, fmt.Errorf("grow positions %v and %v are not increasing", grows[i-1], grows[i])
+		}
+	}
+
+	return GrowingIndex{IndexBased: ib, grows: grows, pos: pos}, nil
+}
+
+// RecordData yields the successors, as the index based record data does,
+// followed by the number of grow positions (3 bits), the bit width w of the grow positions minus one (6 bits)
+// and the grow positions (w bits each)
+func (ed GrowingIndex) RecordData() bitstream.BitStream {
+	width := 1
+	for _, p := range ed.grows {
+		if w := bitWidth(uint64(p)); w > width {
+			width = w
+		}
+	}
+
+	result := ed.IndexBased.RecordData()
+	result.WriteBits(uint64(len(ed.grows)), 3)
+	result.WriteBits(uint64(width-1), growsWidthBits)
+	for _, p := range ed.grows {
+		result.WriteBits(uint64(p), uint(width))
 	}
 
 	return result
 }
 
-func (ed GrowingIndex) Encode(to byte, bs *bitstream.BitStream, pos types.Position) error {
+// PayloadCost yields the number of bits of the encoding of all the transitions of the next list of this encoder
+func (ed GrowingIndex) PayloadCost() int {
+	return ed.payloadCost
+}
+
+func (ed GrowingIndex) Encode(to byte, bs bitstream.BitWriter) error {
 	idx, idxSize, err := ed.indexOf(to)
 	if err != nil {
 		return err
 	}
 
-	dbc := ed.dynamicBitCount(pos)
-	idxSize = min(idxSize, dbc)
+	idxSize = minByte(idxSize, ed.dynamicBitCount(*ed.pos))
+	if uint(idx) >= 1<<idxSize {
+		return fmt.Errorf("index %v of %v does not fit in %v bits at position %v", idx, to, idxSize, *ed.pos)
+	}
 
-	bs.Append(bitstream.NewFromByte(byte(idx), idxSize))
+	bs.WriteBits(uint64(idx), uint(idxSize))
 
 	return nil
 }
 
-func (ed GrowingIndex) Decode(w io.Writer, bs bitstream.BitStream, pos types.Position) error {
-	return errors.New("not yet implemented")
+func (ed GrowingIndex) Decode(bs bitstream.BitReader) (byte, error) {
+	idx, err := bs.ReadBits(uint(minByte(ed.idxSize, ed.dynamicBitCount(*ed.pos))))
+	if err != nil {
+		return 0, err
+	}
+
+	if idx >= uint64(len(ed.next)) {
+		return 0, fmt.Errorf("index %v out of range %v", idx, len(ed.next)-1)
+	}
+
+	return ed.next[idx], nil
 }
 
 func (ed GrowingIndex) dynamicBitCount(pos types.Position) byte {
@@ -53,7 +144,8 @@ func (ed GrowingIndex) dynamicBitCount(pos types.Position) byte {
 	return last + 1
 }
 
-func min(a, b byte) byte {
+// minByte yields the smallest of the given bytes
+func minByte(a, b byte) byte {
 	if a < b {
 		return a
 	}
@@ -11,36 +11,51 @@ import (
 type HuffmanBased struct {
 	dictionary map[byte]bitstream.BitStream
 	tree       huffman.Tree
+	decoder    *lazyDecoder
+}
+
+// lazyDecoder is the decoder of a Huffman tree, it is built when the first symbol is decoded
+type lazyDecoder struct {
+	decoder huffman.Decoder
+	built   bool
 }
 
 func NewHuffmanBased(tree huffman.Tree) HuffmanBased {
 	return HuffmanBased{
 		dictionary: tree.Dictionary(),
 		tree:       tree,
+		decoder:    &lazyDecoder{},
 	}
 }
 
-func NewHuffmanBasedFromNextList(nl table.NextList) HuffmanBased {
+// NewHuffmanBasedFromNextList yields a Huffman encoder for the given next list
+// with codes of at most huffman.DefaultMaxCodeLength bits
+func NewHuffmanBasedFromNextList(nl table.NextList) (HuffmanBased, error) {
+	return NewLimitedHuffmanBasedFromNextList(nl, huffman.DefaultMaxCodeLength)
+}
+
+// NewLimitedHuffmanBasedFromNextList yields a Huffman encoder for the given next list
+// with codes of at most maxLength bits
+func NewLimitedHuffmanBasedFromNextList(nl table.NextList, maxLength int) (HuffmanBased, error) {
 	frequencies := make([]huffman.SymbolFreq, len(nl.List))
 	for i, n := range nl.List {
 		frequencies[i] = huffman.SymbolFreq{Symbol: n.S, Count: uint(n.Count)}
 	}
 
-	tree := huffman.NewTree(frequencies)
-
-	result := HuffmanBased{
-		dictionary: tree.Dictionary(),
-		tree:       tree,
+	tree, err := huffman.NewLengthLimitedTree(frequencies, maxLength)
+	if err != nil {
+		return HuffmanBased{}, err
 	}
 
-	return result
+	return NewHuffmanBased(tree), nil
 }
 
+// RecordData yields the symbols and code lengths of the (canonical) Huffman tree
 func (ed HuffmanBased) RecordData() bitstream.BitStream {
-	return ed.tree.AsBitstream()
+	return ed.tree.AsCanonicalBitstream()
 }
 
-func (ed HuffmanBased) Encode(to byte, bs *bitstream.BitStream) error {
+func (ed HuffmanBased) Encode(to byte, bs bitstream.BitWriter) error {
 	code, exists := ed.dictionary[to]
 	if !exists {
 		return fmt.Errorf("unknown symbol %v in dictionary", to)
@@ -51,7 +66,11 @@ func (ed HuffmanBased) Encode(to byte, bs *bitstream.BitStream) error {
 	return nil
 }
 
-func (ed HuffmanBased) Decode(bs *bitstream.BitStream) (byte, error) {
-	s := ed.tree.Interpret(bs)
-	return s, nil
+func (ed HuffmanBased) Decode(bs bitstream.BitReader) (byte, error) {
+	if !ed.decoder.built {
+		ed.decoder.decoder = huffman.NewDecoder(ed.tree)
+		ed.decoder.built = true
+	}
+
+	return ed.decoder.decoder.Decode(bs)
 }
@@ -0,0 +1,43 @@
+package encoders
+
+import (
+	"testing"
+
+	"github.com/chavacava/next/internal/bitstream"
+)
+
+func TestHuffmanBasedRoundTrip(t *testing.T) {
+	ed, err := NewHuffmanBasedFromNextList(nextList(map[byte]int{65: 10, 66: 3, 67: 1, 68: 1}))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	symbols := []byte("ABACADAAB")
+	bs := bitstream.New()
+	for _, s := range symbols {
+		err := ed.Encode(s, &bs)
+		if err != nil {
+			t.Fatalf("unexpected error encoding %v: %v", s, err)
+		}
+	}
+	if ed.decoder.built {
+		t.Fatal("expected the decoder to be built only when decoding")
+	}
+
+	for _, want := range symbols {
+		got, err := ed.Decode(&bs)
+		if err != nil {
+			t.Fatalf("unexpected error decoding %v: %v", want, err)
+		}
+		if got != want {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestNewLimitedHuffmanBasedFromNextListError(t *testing.T) {
+	_, err := NewLimitedHuffmanBasedFromNextList(nextList(map[byte]int{65: 10, 66: 3, 67: 1}), 1)
+	if err == nil {
+		t.Fatal("error expected building codes of 3 symbols of at most 1 bit")
+	}
+}
@@ -1,9 +1,7 @@
 package encoders
 
 import (
-	"errors"
 	"fmt"
-	"io"
 	"math"
 
 	"github.com/chavacava/next/internal/bitstream"
@@ -11,40 +9,83 @@ import (
 	"github.com/chavacava/next/internal/types"
 )
 
+// IndexBased encodes the transitions of a state with the index of the successor in the list of successors
 type IndexBased struct {
 	next    []byte
 	idxSize byte
 }
 
 func NewIndexBased(nl table.NextList) IndexBased {
+	next := make([]byte, len(nl.List))
+	for i, n := range nl.List {
+		next[i] = n.S
+	}
+
+	return newIndexBased(next)
+}
+
+func newIndexBased(next []byte) IndexBased {
 	result := IndexBased{
-		next:    make([]byte, len(nl.List)),
-		idxSize: minBitsCount(len(nl.List)),
+		next:    next,
+		idxSize: minBitsCount(len(next)),
 	}
 
 	if result.idxSize > 8 {
-		panic(fmt.Sprintf("%v elements requires %v bits\n", len(nl.List), result.idxSize))
+		panic(fmt.Sprintf("%v elements requires %v bits\n", len(next), result.idxSize))
 	}
 
-	for i, n := range nl.List {
-		result.next[i] = n.S
+	return result
+}
+
+// NewIndexBasedFromBS yields an index based decoder from its record data in the given bitstream
+func NewIndexBasedFromBS(bs bitstream.BitReader) (IndexBased, error) {
+	n, err := bs.ReadByte()
+	if err != nil {
+		return IndexBased{}, err
+	}
+
+	next := make([]byte, int(n)+1)
+	for i := range next {
+		next[i], err = bs.ReadByte()
+		if err != nil {
+			return IndexBased{}, err
+		}
+	}
+
+	return newIndexBased(next), nil
+}
+
+// RecordData yields the successors: their number minus one (8 bits) followed by each successor (8 bits)
+func (ed IndexBased) RecordData() bitstream.BitStream {
+	result := bitstream.NewFromFullByte(byte(len(ed.next) - 1))
+	for _, s := range ed.next {
+		result.WriteBits(uint64(s), 8)
 	}
 
 	return result
 }
 
-func (ed IndexBased) Encode(to byte, bs *bitstream.BitStream, pos types.Position) error {
+func (ed IndexBased) Encode(to byte, bs bitstream.BitWriter) error {
 	idx, idxSize, err := ed.indexOf(to)
 	if err != nil {
 		return err
 	}
-	bs.Append(bitstream.NewFromByte(byte(idx), idxSize))
+	bs.WriteBits(uint64(idx), uint(idxSize))
 
 	return nil
 }
 
-func (ed IndexBased) Decode(w io.Writer, bs bitstream.BitStream, pos types.Position) error {
-	return errors.New("not yet implemented")
+func (ed IndexBased) Decode(bs bitstream.BitReader) (byte, error) {
+	idx, err := bs.ReadBits(uint(ed.idxSize))
+	if err != nil {
+		return 0, err
+	}
+
+	if idx >= uint64(len(ed.next)) {
+		return 0, fmt.Errorf("index %v out of range %v", idx, len(ed.next)-1)
+	}
+
+	return ed.next[idx], nil
 }
 
 func (ed IndexBased) indexOf(to byte) (idx types.NextIndex, idxSize byte, err error) {
@@ -61,7 +102,11 @@ func (ed IndexBased) indexOf(to byte) (idx types.NextIndex, idxSize byte, err er
 	return 0, 0, fmt.Errorf("%v not found in the next list", to)
 }
 
-// minBitsCount yields the minimum number of bits required to encode the given number n.
+// minBitsCount yields the minimum number of bits required to encode the given number n of indices.
 func minBitsCount(n int) byte {
+	if n < 2 {
+		return 0
+	}
+
 	return byte(math.Log2(float64(n-1))) + 1
 }
@@ -0,0 +1,276 @@
+package encoders
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/chavacava/next/internal/bitstream"
+	"github.com/chavacava/next/internal/table"
+)
+
+// Frequencies of rANS records are quantized to sum up to 1 << ransScaleBits
+const (
+	ransScaleBits = 12
+	ransTotal     = 1 << ransScaleBits
+	// ransLow is the lower bound of the normalized coder state
+	ransLow = uint32(1) << 23
+	// ransChunkSize is the number of symbols of a chunk, chunks are encoded independently of each other
+	ransChunkSize = 1 << 16
+)
+
+// RANSEncoder is the state of a rANS encoder shared by all the RANS encoders of a file.
+// rANS encodes symbols in reverse order, thus symbols are buffered until a chunk is complete
+// or the encoder is flushed.
+// Use the constructor to create new instances
+type RANSEncoder struct {
+	symbols []uint32 // start << 16 | freq of each encoded symbol
+}
+
+// NewRANSEncoder yields a new rANS encoder
+func NewRANSEncoder() *RANSEncoder {
+	return &RANSEncoder{}
+}
+
+// Flush appends to the given bitstream the encoding of the symbols of the current chunk and resets the encoder.
+// The encoding is the final state of the coder (4 bytes, little endian) followed by the renormalization bytes.
+// Nothing is appended if no symbol was encoded
+func (re *RANSEncoder) Flush(bs bitstream.BitWriter) {
+	if len(re.symbols) == 0 {
+		return
+	}
+
+	x := ransLow
+	out := []byte{} // renormalization bytes, in reverse order
+	for i := len(re.symbols) - 1; i >= 0; i-- {
+		start, freq := re.symbols[i]>>16, re.symbols[i]&0xffff
+		xMax := ((ransLow >> ransScaleBits) << 8) * freq
+		for x >= xMax {
+			out = append(out, byte(x))
+			x >>= 8
+		}
+		x = ((x / freq) << ransScaleBits) + (x % freq) + start
+	}
+
+	for i := uint(0); i < 4; i++ {
+		bs.WriteBits(uint64(byte(x>>(8*i))), 8)
+	}
+	for i := len(out) - 1; i >= 0; i-- {
+		bs.WriteBits(uint64(out[i]), 8)
+	}
+
+	re.symbols = re.symbols[:0]
+}
+
+// RANSDecoder is the state of a rANS decoder shared by all the RANS decoders of a file.
+// Use the constructor to create new instances
+type RANSDecoder struct {
+	x       uint32
+	started bool
+	decoded int // number of symbols decoded from the current chunk
+}
+
+// NewRANSDecoder yields a new rANS decoder
+func NewRANSDecoder() *RANSDecoder {
+	return &RANSDecoder{}
+}
+
+func (rd *RANSDecoder) decode(cum []uint32, bs bitstream.BitReader) (int, error) {
+	if !rd.started {
+		for i := uint(0); i < 4; i++ {
+			b, err := bs.ReadByte()
+			if err != nil {
+				return 0, fmt.Errorf("unable to read the rANS coder state: %v", err)
+			}
+			rd.x |= uint32(b) << (8 * i)
+		}
+		rd.started = true
+		rd.decoded = 0
+	}
+
+	slot := rd.x & (ransTotal - 1)
+	idx := sort.Search(len(cum)-1, func(i int) bool { return cum[i+1] > slot })
+	start, freq := cum[idx], cum[idx+1]-cum[idx]
+	rd.x = freq*(rd.x>>ransScaleBits) + slot - start
+
+	for rd.x < ransLow {
+		b, err := bs.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("unable to renormalize the rANS coder state: %v", err)
+		}
+		rd.x = rd.x<<8 | uint32(b)
+	}
+
+	// the next chunk starts with its own coder state
+	rd.decoded++
+	if rd.decoded == ransChunkSize {
+		if rd.x != ransLow {
+			return 0, fmt.Errorf("corrupted rANS chunk, final state %v", rd.x)
+		}
+		rd.x, rd.started = 0, false
+	}
+
+	return idx, nil
+}
+
+// RANS encodes (decodes) the transitions of a state with a rANS coder shared by all the states
+type RANS struct {
+	symbols []byte
+	cum     []uint32 // cum[i] is the sum of the quantized frequencies of symbols before symbols[i]
+	encoder *RANSEncoder
+	decoder *RANSDecoder
+}
+
+func newRANS(symbols []byte, freqs []uint32) RANS {
+	cum := make([]uint32, len(freqs)+1)
+	for i, f := range freqs {
+		cum[i+1] = cum[i] + f
+	}
+
+	return RANS{symbols: symbols, cum: cum}
+}
+
+// NewRANSFromNextList yields a rANS encoder for the given next list, using the given shared encoder.
+// The counts of the list are quantized to sum up to 1 << 12
+func NewRANSFromNextList(nl table.NextList, encoder *RANSEncoder) RANS {
+	if len(nl.List) > 256 {
+		panic(fmt.Sprintf("%v elements can not be encoded in a rANS record", len(nl.List)))
+	}
+
+	var total float64
+	for _, n := range nl.List {
+		total += float64(n.Count)
+	}
+
+	symbols := make([]byte, len(nl.List))
+	freqs := make([]uint32, len(nl.List))
+	var sum uint32
+	largest := 0
+	for i, n := range nl.List {
+		symbols[i] = n.S
+		freqs[i] = uint32(float64(n.Count) * ransTotal / total)
+		if freqs[i] == 0 {
+			freqs[i] = 1
+		}
+		sum += freqs[i]
+		if freqs[i] > freqs[largest] {
+			largest = i
+		}
+	}
+
+	// fix rounding errors
+	for sum < ransTotal {
+		freqs[largest]++
+		sum++
+	}
+	for sum > ransTotal {
+		largest = 0
+		for i, f := range freqs {
+			if f > freqs[largest] {
+				largest = i
+			}
+		}
+		freqs[largest]--
+		sum--
+	}
+
+	result := newRANS(symbols, freqs)
+	result.encoder = encoder
+
+	return result
+}
+
+// NewRANSFromBS yields a rANS decoder from its record data in the given bitstream, using the given shared decoder
+func NewRANSFromBS(bs bitstream.BitReader, decoder *RANSDecoder) (RANS, error) {
+	n, err := bs.ReadByte()
+	if err != nil {
+		return RANS{}, err
+	}
+
+	count := int(n) + 1
+	width, err := bs.ReadBits(freqWidthBits)
+	if err != nil {
+		return RANS{}, err
+	}
+	width++
+
+	symbols := make([]byte, count)
+	freqs := make([]uint32, count)
+	var sum uint32
+	for i := 0; i < count; i++ {
+		symbols[i], err = bs.ReadByte()
+		if err != nil {
+			return RANS{}, err
+		}
+
+		if i == count-1 {
+			if sum >= ransTotal {
+				return RANS{}, fmt.Errorf("rANS frequencies sum up to %v, more than %v", sum, ransTotal)
+			}
+			freqs[i] = ransTotal - sum
+			break
+		}
+
+		f, err := bs.ReadBits(uint(width))
+		if err != nil {
+			return RANS{}, err
+		}
+		if f == 0 {
+			return RANS{}, fmt.Errorf("null frequency for symbol %v", symbols[i])
+		}
+		freqs[i] = uint32(f)
+		sum += freqs[i]
+	}
+
+	result := newRANS(symbols, freqs)
+	result.decoder = decoder
+
+	return result, nil
+}
+
+// RecordData yields the symbols and their quantized frequencies:
+// the number of symbols minus one (8 bits), the bit width w of frequencies minus one (4 bits)
+// followed by each symbol (8 bits) and its frequency (w bits).
+// The frequency of the last symbol is omitted, frequencies sum up to 1 << 12
+func (ed RANS) RecordData() bitstream.BitStream {
+	last := len(ed.symbols) - 1
+	width := 1
+	for i := 0; i < last; i++ {
+		if w := bitWidth(uint64(ed.cum[i+1] - ed.cum[i])); w > width {
+			width = w
+		}
+	}
+
+	result := bitstream.NewFromFullByte(byte(last))
+	result.WriteBits(uint64(width-1), freqWidthBits)
+	for i, s := range ed.symbols {
+		result.WriteBits(uint64(s), 8)
+		if i < last {
+			result.WriteBits(uint64(ed.cum[i+1]-ed.cum[i]), uint(width))
+		}
+	}
+
+	return result
+}
+
+func (ed RANS) Encode(to byte, bs bitstream.BitWriter) error {
+	for i, s := range ed.symbols {
+		if s == to {
+			ed.encoder.symbols = append(ed.encoder.symbols, ed.cum[i]<<16|(ed.cum[i+1]-ed.cum[i]))
+			if len(ed.encoder.symbols) == ransChunkSize {
+				ed.encoder.Flush(bs)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown symbol %v in rANS encoder", to)
+}
+
+func (ed RANS) Decode(bs bitstream.BitReader) (byte, error) {
+	idx, err := ed.decoder.decode(ed.cum, bs)
+	if err != nil {
+		return 0, err
+	}
+
+	return ed.symbols[idx], nil
+}
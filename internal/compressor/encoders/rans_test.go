@@ -0,0 +1,89 @@
+package encoders
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chavacava/next/internal/bitstream"
+)
+
+func TestRANSQuantization(t *testing.T) {
+	for name, counts := range testLists {
+		counts := counts
+		t.Run(name,
+			func(t *testing.T) {
+				ed := NewRANSFromNextList(nextList(counts), NewRANSEncoder())
+				if len(ed.symbols) != len(counts) {
+					t.Fatalf("expected %d symbols, got %d", len(counts), len(ed.symbols))
+				}
+				for i, s := range ed.symbols {
+					if f := ed.cum[i+1] - ed.cum[i]; f < 1 {
+						t.Fatalf("null frequency for symbol %v", s)
+					}
+				}
+				if sum := ed.cum[len(ed.cum)-1]; sum != ransTotal {
+					t.Fatalf("expected frequencies to sum up to %v, got %v", ransTotal, sum)
+				}
+			},
+		)
+	}
+}
+
+func TestRANSRecordDataRoundTrip(t *testing.T) {
+	for name, counts := range testLists {
+		counts := counts
+		t.Run(name,
+			func(t *testing.T) {
+				ed := NewRANSFromNextList(nextList(counts), NewRANSEncoder())
+				record := ed.RecordData()
+				got, err := NewRANSFromBS(&record, NewRANSDecoder())
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if record.Remaining() != 0 {
+					t.Fatalf("expected the whole record to be read, %d bits left", record.Remaining())
+				}
+				if !reflect.DeepEqual(ed.symbols, got.symbols) || !reflect.DeepEqual(ed.cum, got.cum) {
+					t.Fatalf("expected\n\t%v %v\ngot\n\t%v %v", ed.symbols, ed.cum, got.symbols, got.cum)
+				}
+			},
+		)
+	}
+}
+
+func TestNewRANSFromBSErrors(t *testing.T) {
+	tt := map[string]struct {
+		record  bitstream.BitStream
+		wantErr string
+	}{
+		"sum of the scale before the last symbol": {
+			record:  record(12, []byte{65, 66, 67}, []uint64{3000, 1096}),
+			wantErr: "rANS frequencies sum up to 4096, more than 4096",
+		},
+		"sum over the scale": {
+			record:  record(13, []byte{65, 66}, []uint64{5000}),
+			wantErr: "rANS frequencies sum up to 5000, more than 4096",
+		},
+		"null frequency": {
+			record:  record(12, []byte{65, 66}, []uint64{0}),
+			wantErr: "null frequency for symbol 65",
+		},
+		"truncated record": {
+			record:  record(12, []byte{65, 66, 67}, []uint64{1000}),
+			wantErr: "",
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name,
+			func(t *testing.T) {
+				_, err := NewRANSFromBS(&tc.record, NewRANSDecoder())
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,462 @@
+package compressor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/chavacava/next/internal/huffman"
+	"github.com/chavacava/next/internal/table"
+)
+
+// DefaultBlockSize is the default maximum length of the content of the blocks of a framed file
+const DefaultBlockSize = 1 << 20
+
+// frameMarker starts each frame of a framed file
+var frameMarker = []byte{'N', 'X', 'T', 'F'}
+
+// frameHeaderSize is the number of bytes of a frame header: marker, block and content lengths, checksum
+const frameHeaderSize = 13
+
+// indexMarker starts the index of the blocks of a framed file
+var indexMarker = []byte{'N', 'X', 'T', 'I'}
+
+// indexEntry represents the position of a block in the index of a framed file
+type indexEntry struct {
+	ContentOffset uint64 // offset of the content of the block
+	FrameOffset   uint64 // offset of the frame of the block, relative to the start of the file
+}
+
+// frameHeader represents the header of a frame
+type frameHeader struct {
+	blockLength   uint32 // length of the compressed block
+	contentLength uint32 // length of the content of the block
+}
+
+// BlockCompressor represents a compressor that cuts its input in blocks compressed independently
+// of each other, with their own transitions table, and writes them in a framed file.
+// Use the constructor to create new instances
+type BlockCompressor struct {
+	newTable  func(block io.ReadSeeker, order int) table.TransitionsTable
+	order     int
+	coder     Coder
+	blockSize int
+	workers   int // number of blocks compressed concurrently
+	metadata  Metadata
+}
+
+// NewBlockCompressor yields a new compressor cutting its input in blocks of the given size,
+// the transitions table of each block is built by newTable with the given context order
+// and the transitions are encoded with the given coder. Blocks are compressed one at a time
+func NewBlockCompressor(newTable func(block io.ReadSeeker, order int) table.TransitionsTable, order int, coder Coder, blockSize int) BlockCompressor {
+	return BlockCompressor{
+		newTable:  newTable,
+		order:     order,
+		coder:     coder,
+		blockSize: blockSize,
+		workers:   1,
+	}
+}
+
+// WithWorkers yields a copy of this compressor that compresses up to n blocks concurrently.
+// The compressed file does not depend on the number of workers, but up to n + 2 blocks are held in memory
+func (c BlockCompressor) WithWorkers(n int) BlockCompressor {
+	c.workers = n
+	return c
+}
+
+// WithMetadata yields a copy of this compressor that stores the given metadata in the header of the compressed files.
+// The compression parameters of the metadata are set by the compressor
+func (c BlockCompressor) WithMetadata(m Metadata) BlockCompressor {
+	c.metadata = m
+	return c
+}
+
+// Compress compresses the content from input and writes the resulting framed file, with the index of its blocks, in the given writer.
+// Blocks are read from the input while the previous ones are compressed by the workers: the input needs not to be seekable
+func (c BlockCompressor) Compress(input io.Reader, w io.Writer) error {
+	fw, err := c.NewFramedWriter(w)
+	if err != nil {
+		return err
+	}
+
+	eof := false
+	err = fw.writeBlocks(func() ([]byte, error) {
+		if eof {
+			return nil, io.EOF
+		}
+
+		content := make([]byte, c.blockSize)
+		n, err := io.ReadFull(input, content)
+		if err == io.ErrUnexpectedEOF {
+			eof, err = true, nil // last block
+		}
+
+		return content[:n], err
+	})
+	if err != nil {
+		return err
+	}
+
+	return fw.Close()
+}
+
+// FramedWriter writes a framed file block by block.
+// Use BlockCompressor.NewFramedWriter to create new instances
+type FramedWriter struct {
+	c             BlockCompressor
+	w             *offsetWriter
+	index         []indexEntry
+	contentLength uint64
+}
+
+// NewFramedWriter writes the header of a framed file in w and yields a writer of its blocks.
+// Error will arise if the block size of the compressor is invalid or if the header can not be written
+func (c BlockCompressor) NewFramedWriter(w io.Writer) (*FramedWriter, error) {
+	if c.blockSize <= 0 || uint64(c.blockSize) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid block size %d", c.blockSize)
+	}
+	if c.workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d", c.workers)
+	}
+
+	ow := &offsetWriter{w: w}
+	metadata := c.metadata
+	metadata.Parameters = &Parameters{Coder: c.coder, MaxCodeLength: huffman.DefaultMaxCodeLength}
+	err := WriteHeader(ow, Header{BlockSize: uint32(c.blockSize), Metadata: metadata})
+	if err != nil {
+		return nil, err
+	}
+
+	return &FramedWriter{c: c, w: ow}, nil
+}
+
+// WriteBlock compresses the given content as a block and writes its frame, an empty content is not written.
+// Blocks shorter than the block size may be written anywhere in the file
+func (fw *FramedWriter) WriteBlock(content []byte) error {
+	return fw.WriteBlocks([][]byte{content})
+}
+
+// WriteBlocks compresses the given contents as blocks, up to the number of workers of the compressor
+// at a time, and writes their frames in the order of the contents. Empty contents are not written
+func (fw *FramedWriter) WriteBlocks(contents [][]byte) error {
+	for _, content := range contents {
+		if len(content) > fw.c.blockSize {
+			return fmt.Errorf("block of %d bytes, longer than the block size %d", len(content), fw.c.blockSize)
+		}
+	}
+
+	next := 0
+	return fw.writeBlocks(func() ([]byte, error) {
+		if next == len(contents) {
+			return nil, io.EOF
+		}
+		next++
+		return contents[next-1], nil
+	})
+}
+
+// blockJob is a block compressed by a worker
+type blockJob struct {
+	content []byte
+	block   bytes.Buffer
+	err     error
+	done    chan struct{} // closed once the block is compressed
+}
+
+// writeBlocks compresses the contents yielded by next, until it yields io.EOF, and writes their frames.
+// A reader goroutine feeds the jobs of the blocks to the workers and, in the same order, to the writer
+// that writes the frame of each block once it is compressed.
+// Up to workers blocks are queued, thus reading the contents is bounded by the writing of the frames
+func (fw *FramedWriter) writeBlocks(next func() ([]byte, error)) error {
+	jobs := make(chan *blockJob, fw.c.workers)  // blocks to compress
+	queue := make(chan *blockJob, fw.c.workers) // blocks to write, in order
+	stop := make(chan struct{})                 // closed if the frames can not be written
+	var readErr error
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(queue)
+		for {
+			content, err := next()
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+			if len(content) == 0 {
+				continue
+			}
+
+			job := &blockJob{content: content, done: make(chan struct{})}
+			select {
+			case queue <- job:
+			case <-stop:
+				return
+			}
+			jobs <- job
+		}
+	}()
+
+	for i := 0; i < fw.c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.err = fw.c.compressBlock(job.content, &job.block)
+				close(job.done)
+			}
+		}()
+	}
+
+	err := fw.writeQueue(queue)
+	if err != nil {
+		close(stop)
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	return readErr
+}
+
+// writeQueue writes the frames of the queued blocks, in the order of the queue, once they are compressed
+func (fw *FramedWriter) writeQueue(queue <-chan *blockJob) error {
+	for job := range queue {
+		<-job.done
+		if job.err != nil {
+			return job.err
+		}
+
+		fw.index = append(fw.index, indexEntry{ContentOffset: fw.contentLength, FrameOffset: fw.w.n})
+		err := writeFrame(fw.w, job.block.Bytes(), len(job.content))
+		if err != nil {
+			return err
+		}
+		fw.contentLength += uint64(len(job.content))
+	}
+
+	return nil
+}
+
+// Close writes the end of the frames and the index of the blocks, the underlying writer is not closed
+func (fw *FramedWriter) Close() error {
+	err := writeFrame(fw.w, nil, 0) // end of the frames
+	if err != nil {
+		return err
+	}
+
+	return writeIndex(fw.w, fw.index, fw.contentLength)
+}
+
+// writeIndex writes the index of the given blocks, the writer being at the end of the frames
+func writeIndex(w *offsetWriter, index []indexEntry, contentLength uint64) error {
+	indexOffset := w.n
+	crc := crc32.NewIEEE()
+	mw := io.MultiWriter(w, crc)
+	var fields = []interface{}{
+		indexMarker,
+		uint32(len(index)),
+		index,
+		contentLength,
+	}
+	for _, v := range fields {
+		err := binary.Write(mw, binary.LittleEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := binary.Write(w, binary.LittleEndian, crc.Sum32())
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, indexOffset)
+}
+
+// offsetWriter counts the bytes written to the underlying writer
+type offsetWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	ow.n += uint64(n)
+	return n, err
+}
+
+// compressBlock writes the single stream file of the given content in w
+func (c BlockCompressor) compressBlock(content []byte, w io.Writer) error {
+	tt := c.newTable(bytes.NewReader(content), c.order)
+	return NewCompressorWithCoder(tt, c.coder).Compress(bytes.NewReader(content), w)
+}
+
+// writeFrame writes the frame of the given compressed block of contentLength bytes of content
+func writeFrame(w io.Writer, block []byte, contentLength int) error {
+	if uint64(len(block)) > math.MaxUint32 {
+		return fmt.Errorf("compressed block of %d bytes, longer than the maximum length of a frame", len(block))
+	}
+
+	var fh [frameHeaderSize]byte
+	copy(fh[:], frameMarker)
+	binary.LittleEndian.PutUint32(fh[4:], uint32(len(block)))
+	binary.LittleEndian.PutUint32(fh[8:], uint32(contentLength))
+	fh[12] = checksum(fh[:12])
+
+	_, err := w.Write(fh[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(block)
+
+	return err
+}
+
+// readFrameHeader reads a frame header from the given reader, nothing is read if it is not a valid frame header
+func readFrameHeader(r *bufio.Reader) (frameHeader, error) {
+	fh, err := r.Peek(frameHeaderSize)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return frameHeader{}, fmt.Errorf("unable to read the frame header: %w", err)
+	}
+	if !bytes.Equal(fh[:len(frameMarker)], frameMarker) {
+		return frameHeader{}, errors.New("frame marker not found")
+	}
+	if cs := checksum(fh[:12]); cs != fh[12] {
+		return frameHeader{}, IntegrityError{What: "frame header", Expected: uint32(fh[12]), Actual: uint32(cs)}
+	}
+
+	result := frameHeader{
+		blockLength:   binary.LittleEndian.Uint32(fh[4:]),
+		contentLength: binary.LittleEndian.Uint32(fh[8:]),
+	}
+	r.Discard(frameHeaderSize)
+
+	return result, nil
+}
+
+// resync discards the bytes of the given reader up to the next frame marker, it yields false if there is none
+func resync(r *bufio.Reader) bool {
+	for {
+		p, err := r.Peek(len(frameMarker))
+		if bytes.Equal(p, frameMarker) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		r.Discard(1)
+	}
+}
+
+// RecoveryError is the error of recovering a damaged framed file, it holds the errors of the skipped blocks
+type RecoveryError struct {
+	Skipped []error
+}
+
+func (e RecoveryError) Error() string {
+	return fmt.Sprintf("%d damaged blocks skipped, first error: %v", len(e.Skipped), e.Skipped[0])
+}
+
+// decompressFrames decompresses the frames of a framed file, its header being already read.
+// If skipDamaged is set, damaged blocks are skipped and reported at the end by a RecoveryError
+func (d Decompressor) decompressFrames(r io.Reader, w io.Writer, skipDamaged bool) error {
+	br := bufio.NewReader(r)
+	block := new(bytes.Buffer)
+	content := new(bytes.Buffer)
+	var skipped []error
+	for i := 1; ; i++ {
+		fh, err := readFrameHeader(br)
+		if err != nil {
+			br.Discard(1) // looks for the next frame past the marker of the damaged one
+		} else if fh.blockLength == 0 {
+			break // end of the frames
+		} else {
+			err = d.decompressBlock(br, fh, block, content)
+		}
+		if err == nil {
+			_, err = w.Write(content.Bytes())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = fmt.Errorf("block #%d: %w", i, err)
+		if !skipDamaged {
+			return err
+		}
+		skipped = append(skipped, err)
+		if !resync(br) {
+			break
+		}
+	}
+
+	if len(skipped) > 0 {
+		return RecoveryError{Skipped: skipped}
+	}
+
+	return nil
+}
+
+// decompressBlock reads the block of the given frame header from r and decompresses it in content
+func (d Decompressor) decompressBlock(r io.Reader, fh frameHeader, block, content *bytes.Buffer) error {
+	block.Reset()
+	content.Reset()
+	_, err := io.CopyN(block, r, int64(fh.blockLength))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("unable to read the block: %w", err)
+	}
+
+	header, err := ReadHeader(block)
+	if err != nil {
+		return fmt.Errorf("error while reading the block header: %w", err)
+	}
+	if header.BlockSize > 0 {
+		return errors.New("framed file in a block")
+	}
+
+	err = d.decompressStream(header, block, &limitedWriter{w: content, n: int(fh.contentLength)})
+	if err != nil {
+		return err
+	}
+	if content.Len() != int(fh.contentLength) {
+		return IntegrityError{What: "block", Err: fmt.Errorf("content of %d bytes, expected %d", content.Len(), fh.contentLength)}
+	}
+
+	return nil
+}
+
+// limitedWriter writes at most n bytes in the underlying writer, writing more is an integrity error of the block
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > lw.n {
+		return 0, IntegrityError{What: "block", Err: errors.New("content longer than in its frame header")}
+	}
+
+	n, err := lw.w.Write(p)
+	lw.n -= n
+	return n, err
+}
@@ -0,0 +1,304 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/chavacava/next/internal/table"
+)
+
+// framedInput is cut in 4 blocks of 64 bytes and a last one of 8 bytes
+var framedInput = strings.Repeat("Simplicity is prerequisite for reliability. ", 6)
+
+func compressFramed(t *testing.T, input string, newTable func(io.ReadSeeker, int) table.TransitionsTable, coder Coder, blockSize int) []byte {
+	t.Helper()
+	compressed := new(bytes.Buffer)
+	err := NewBlockCompressor(newTable, 2, coder, blockSize).Compress(strings.NewReader(input), compressed)
+	if err != nil {
+		t.Fatalf("unexpected compression error %v", err)
+	}
+
+	return compressed.Bytes()
+}
+
+func TestBlockCompressorRoundTrip(t *testing.T) {
+	builders := map[string]func(io.ReadSeeker, int) table.TransitionsTable{
+		"transducer": table.New,
+		"blended":    table.NewBlended,
+		"adaptive": func(_ io.ReadSeeker, order int) table.TransitionsTable {
+			return table.NewAdaptive(order)
+		},
+	}
+
+	tt := map[string]struct {
+		input     string
+		blockSize int
+	}{
+		"empty":                {"", 64},
+		"one byte":             {"x", 64},
+		"exactly one block":    {framedInput[:64], 64},
+		"several blocks":       {framedInput, 64},
+		"one byte blocks":      {framedInput[:10], 1},
+		"block larger than in": {framedInput, DefaultBlockSize},
+	}
+
+	for name, tc := range tt {
+		for model, newTable := range builders {
+			for _, coder := range []Coder{CoderHuffman, CoderArithmetic, CoderRANS} {
+				tc, newTable, coder := tc, newTable, coder
+				t.Run(fmt.Sprintf("%s %s coder %d", name, model, coder),
+					func(t *testing.T) {
+						compressed := compressFramed(t, tc.input, newTable, coder, tc.blockSize)
+
+						header, err := ReadHeader(bytes.NewReader(compressed))
+						if err != nil {
+							t.Fatalf("unexpected error %v", err)
+						}
+						if header.Version != versionNumber || header.BlockSize != uint32(tc.blockSize) {
+							t.Fatalf("expected a framed header of block size %d, got %+v", tc.blockSize, header)
+						}
+
+						got := new(bytes.Buffer)
+						err = NewDecompressor().Decompress(bytes.NewReader(compressed), got)
+						if err != nil {
+							t.Fatalf("unexpected decompression error %v", err)
+						}
+						if got.String() != tc.input {
+							t.Fatalf("expected\n\t%q\ngot\n\t%q", tc.input, got.String())
+						}
+					},
+				)
+			}
+		}
+	}
+}
+
+func TestBlockCompressorInvalidBlockSize(t *testing.T) {
+	err := NewBlockCompressor(table.New, 2, CoderHuffman, 0).Compress(strings.NewReader("abc"), new(bytes.Buffer))
+	if err == nil {
+		t.Fatal("error expected compressing with a block size of 0")
+	}
+}
+
+// frameOffsets yields the offsets of the frames of the given framed file, up to the end frame
+func frameOffsets(t *testing.T, compressed []byte) []int {
+	t.Helper()
+	r := bytes.NewReader(compressed)
+	_, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var result []int
+	for pos := len(compressed) - r.Len(); ; {
+		result = append(result, pos)
+		blockLength := int(binary.LittleEndian.Uint32(compressed[pos+4:]))
+		if blockLength == 0 {
+			break // end frame
+		}
+		pos += frameHeaderSize + blockLength
+	}
+
+	return result
+}
+
+func TestRecoverDamagedBlocks(t *testing.T) {
+	const blockSize = 64
+	compressed := compressFramed(t, framedInput, table.NewBlended, CoderHuffman, blockSize)
+	frames := frameOffsets(t, compressed)
+	if len(frames) != 6 {
+		t.Fatalf("expected 5 blocks and the end frame, got %d frames", len(frames))
+	}
+
+	blocks := func(indexes ...int) string {
+		result := ""
+		for _, i := range indexes {
+			end := (i + 1) * blockSize
+			if end > len(framedInput) {
+				end = len(framedInput)
+			}
+			result += framedInput[i*blockSize : end]
+		}
+		return result
+	}
+
+	tt := map[string]struct {
+		damage      func(f []byte) []byte
+		want        string
+		wantSkipped int
+	}{
+		"damaged payload": {
+			damage:      func(f []byte) []byte { f[frames[2]-trailerSize-1] ^= 0xff; return f },
+			want:        blocks(0, 2, 3, 4),
+			wantSkipped: 1,
+		},
+		"damaged block length": {
+			damage:      func(f []byte) []byte { f[frames[1]+4] ^= 0x01; return f },
+			want:        blocks(0, 2, 3, 4),
+			wantSkipped: 1,
+		},
+		"damaged marker": {
+			damage:      func(f []byte) []byte { f[frames[3]] = 'X'; return f },
+			want:        blocks(0, 1, 2, 4),
+			wantSkipped: 1,
+		},
+		"missing end frame": {
+			damage:      func(f []byte) []byte { return f[:frames[5]] },
+			want:        blocks(0, 1, 2, 3, 4),
+			wantSkipped: 1,
+		},
+		"truncated block": {
+			damage:      func(f []byte) []byte { return f[:frames[4]+20] },
+			want:        blocks(0, 1, 2, 3),
+			wantSkipped: 1,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				damaged := tc.damage(append([]byte{}, compressed...))
+
+				err := NewDecompressor().Decompress(bytes.NewReader(damaged), new(bytes.Buffer))
+				if err == nil {
+					t.Fatal("decompression error expected")
+				}
+
+				got := new(bytes.Buffer)
+				err = NewDecompressor().Recover(bytes.NewReader(damaged), got)
+				var re RecoveryError
+				if !errors.As(err, &re) {
+					t.Fatalf("expected a recovery error, got %v", err)
+				}
+				if len(re.Skipped) != tc.wantSkipped {
+					t.Fatalf("expected %d skipped blocks, got %v", tc.wantSkipped, re.Skipped)
+				}
+				if got.String() != tc.want {
+					t.Fatalf("expected\n\t%q\ngot\n\t%q", tc.want, got.String())
+				}
+			},
+		)
+	}
+
+	got := new(bytes.Buffer)
+	err := NewDecompressor().Recover(bytes.NewReader(compressed), got)
+	if err != nil || got.String() != framedInput {
+		t.Fatalf("expected to recover the undamaged file, got %q, %v", got.String(), err)
+	}
+}
+
+func TestBlockContentLengthMismatch(t *testing.T) {
+	const blockSize = 64
+	compressed := compressFramed(t, framedInput, table.NewBlended, CoderHuffman, blockSize)
+	frames := frameOffsets(t, compressed)
+
+	for name, contentLength := range map[string]uint32{"shorter": blockSize - 1, "longer": blockSize + 1} {
+		contentLength := contentLength
+		t.Run(name,
+			func(t *testing.T) {
+				damaged := append([]byte{}, compressed...)
+				fh := damaged[frames[1] : frames[1]+frameHeaderSize]
+				binary.LittleEndian.PutUint32(fh[8:], contentLength)
+				fh[12] = checksum(fh[:12])
+
+				err := NewDecompressor().Decompress(bytes.NewReader(damaged), new(bytes.Buffer))
+				var ie IntegrityError
+				if !errors.As(err, &ie) || ie.What != "block" {
+					t.Fatalf("expected an integrity error of the block, got %v", err)
+				}
+			},
+		)
+	}
+}
+
+func TestBlockCompressorWorkers(t *testing.T) {
+	input := strings.Repeat(framedInput, 10)
+	compress := func(workers int) []byte {
+		compressed := new(bytes.Buffer)
+		err := NewBlockCompressor(table.NewBlended, 3, CoderHuffman, 100).WithWorkers(workers).Compress(strings.NewReader(input), compressed)
+		if err != nil {
+			t.Fatalf("unexpected compression error with %d workers %v", workers, err)
+		}
+		return compressed.Bytes()
+	}
+
+	want := compress(1)
+	for _, workers := range []int{2, 3, 8, 100} {
+		if got := compress(workers); !bytes.Equal(want, got) {
+			t.Fatalf("expected the same compressed file with %d workers as with one worker", workers)
+		}
+	}
+
+	got := new(bytes.Buffer)
+	err := NewDecompressor().Decompress(bytes.NewReader(want), got)
+	if err != nil || got.String() != input {
+		t.Fatalf("expected to decompress the input, got %v", err)
+	}
+
+	err = NewBlockCompressor(table.New, 2, CoderHuffman, 100).WithWorkers(0).Compress(strings.NewReader(input), new(bytes.Buffer))
+	if err == nil {
+		t.Fatal("error expected compressing with 0 workers")
+	}
+}
+
+func TestFramedWriterWorkers(t *testing.T) {
+	// blocks of various lengths, some of them empty
+	contents := [][]byte{}
+	for i := 0; i < 40; i++ {
+		contents = append(contents, []byte(framedInput[:(i*37)%len(framedInput)]))
+	}
+
+	write := func(workers int) []byte {
+		compressed := new(bytes.Buffer)
+		fw, err := NewBlockCompressor(table.NewBlended, 2, CoderHuffman, len(framedInput)).WithWorkers(workers).NewFramedWriter(compressed)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		err = fw.WriteBlocks(contents[:25])
+		if err == nil {
+			err = fw.WriteBlocks(contents[25:])
+		}
+		if err == nil {
+			err = fw.Close()
+		}
+		if err != nil {
+			t.Fatalf("unexpected error writing the blocks with %d workers %v", workers, err)
+		}
+		return compressed.Bytes()
+	}
+
+	want := write(1)
+	for _, workers := range []int{2, 4, 64} {
+		if got := write(workers); !bytes.Equal(want, got) {
+			t.Fatalf("expected the same compressed file with %d workers as with one worker", workers)
+		}
+	}
+}
+
+// failingWriter fails once n bytes are written
+type failingWriter struct {
+	n int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.n {
+		return 0, errors.New("write failure")
+	}
+	fw.n -= len(p)
+	return len(p), nil
+}
+
+func TestBlockCompressorWriteError(t *testing.T) {
+	input := strings.Repeat(framedInput, 100)
+	for _, workers := range []int{1, 4} {
+		err := NewBlockCompressor(table.New, 2, CoderHuffman, 64).WithWorkers(workers).Compress(strings.NewReader(input), &failingWriter{n: 500})
+		if err == nil {
+			t.Fatalf("error expected writing the frames with %d workers", workers)
+		}
+	}
+}
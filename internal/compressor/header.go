@@ -3,10 +3,15 @@ package compressor
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
 	"reflect"
+	"time"
 
+	"github.com/chavacava/next/internal/bitstream"
 	"github.com/chavacava/next/internal/types"
 )
 
@@ -15,18 +20,72 @@ import (
 Position	Size 	What 		 		Example/Comment
 0        	9    	magic      			\211 N E X T \r \n \032 \n
 9			1		version number		major version
-10			2		data offset			120 (relative to the start of the file)
-12			1		root byte			65
-13			8		original length 	25487852
-21			1		trans recods count	number of transition records in this file
-22			1		chksum				addition (overflowed) of previous bytes
-xx			x		trans records
-
+10			2		data offset			120 (position of the trans records, relative to the start of the file)
+12			1		model				0 (see models below)
+13			1		context order		2 (number of bytes of a transducer state)
+14			1		flags				bit 0: unknown length, the original length is only in the trailer
+15			8		original length 	25487852 (absent if the length is unknown)
+23			4		trans recods count	number of transition records in this file
+27			r		root				the first r bytes of the content (see models below)
+27+r		e		extensions			optional fields (see extensions below)
+27+r+e		1		chksum				addition (overflowed) of previous bytes
+xx			x		trans records		at the data offset
+xx			x		payload				encoded content, padded with 0s to a byte boundary
+xx			8		original length		25487852 (trailer)
+xx			4		content CRC			CRC-32 (IEEE) of the original content, little endian (trailer)
+
+The trailer being the last 12 bytes of the file, the payload ends 12 bytes before the end of the file
+and it can be decoded without knowing the original length until the end of the file is reached.
+Files of the transducer model have a known length.
+
+An empty content has an empty root, no trans records and an empty payload.
+With the transducer model, a content no longer than the context order is entirely in the root
+and the file has no trans records.
+
+Fields added to the header by later releases of a format version are placed before the checksum
+(as extensions since version 4): readers skip them, the checksum being the byte before the data offset.
+Files of a greater version number than the one of the reader are rejected.
+
+# Extensions
+Each extension is a tag (1 byte), the length l of its value (LEB128 varint) and its value (l bytes).
+Readers skip extensions of unknown tags.
+
+Tag		What				Value
+1		name				original file name (UTF-8)
+2		modification time	Unix time in nanoseconds of the original file (8 bytes, little endian)
+3		mode				permission and mode bits of the original file (4 bytes, little endian)
+4		comment				free form comment (UTF-8)
+5		parameters			coder (1 byte: 0 Huffman, 1 arithmetic, 2 rANS) and maximum Huffman code length (1 byte)
+
+# Models
+
+## Transducer (model #0)
+States are the last o = context order bytes of the content.
+The root holds the first r = min(context order, original length) bytes of the content.
+
+## Blended (model #1)
+States are the last 0 to o = context order bytes of the content.
+A symbol is encoded by the highest order state having a record, if the symbol is not
+one of its successors the state encodes its escape symbol and the next lower order state is used.
+The root is empty (r = 0).
+
+## Adaptive (model #2)
+States are those of the blended model but the file has no transitions records:
+the transducer starts empty and is updated after each symbol while decoding.
+The root is empty (r = 0).
 
 #  Transitions Record
 Position	Size 	What 		 	Example/Comment
 0			1		record type		1
-1			1		from			65
+1			o		from			65 66 (o = context order)
+x 			~ 		record data
+
+#  Transitions Record (Blended model)
+Position	Size 	What 		 	Example/Comment
+0			1		record type		129 (bit 7 set if the record has an escape symbol)
+1			1		state length	2
+2			l		from			65 66 (l = state length)
+x			1		escape symbol	0 (only if bit 7 of the record type is set)
 x 			~ 		record data
 
 # Record data by type
@@ -35,16 +94,151 @@ x 			~ 		record data
 Position	Size 	What 		 	Example/Comment
 0			1		to
 
-## Huffman Tree (type #1)
+## Huffman Tree (type #1, read only)
 Position	Size 	What 		 	Example/Comment
 0			~		bs of tree
 
+## Canonical Huffman (type #4)
+Position	Size 	What 		 	Example/Comment
+0			~		symbols count	n, Elias gamma coded
+x			1		first symbol	65
+x			3 bits	Rice parameter	k (only if n > 1)
+x			~		symbols			n - 1 times: Rice coded (parameter k) difference minus one with the previous symbol
+x			3 bits	length width	w - 1 (only if n > 2)
+x			~		code lengths	n - 1 times: code length minus one (w bits) of the symbol (only if n > 2)
+
+The code length of the last symbol is implied by the others, the code being complete.
+With two symbols, both codes are one bit long.
+Codes are assigned in increasing order of code length, then of symbol.
+
+## Index (type #5)
+Position	Size 	What 		 	Example/Comment
+0			1		symbols count	n - 1
+1			n		symbols			65 66 67
+
+A symbol is coded by its index in the list of symbols, on the minimum number of bits
+required to code n - 1.
+
+## Growing Index (type #6, transducer model only)
+Position	Size 	What 		 	Example/Comment
+0			1		symbols count	n - 1
+1			n		symbols			65 66 67 (in order of first occurrence in the content)
+x			3 bits	grows count		g
+x			6 bits	grows width		w - 1
+x			~		grows			g times: position (w bits) in the content, after the root, of a grow
+
+A symbol is coded by its index in the list of symbols, on one bit plus the number of grows
+at or before the position of the symbol (at most the number of bits of an index record).
+
+## Arithmetic (type #2)
+Position	Size 	What 		 	Example/Comment
+0			1		symbols count	n - 1
+1			4 bits	freq width		w - 1 (frequencies are at most 12 bits long)
+x			~		symbols			n times: symbol (8 bits) and its frequency (w bits)
+
+## rANS (type #3)
+Position	Size 	What 		 	Example/Comment
+0			1		symbols count	n - 1
+1			4 bits	freq width		w - 1
+x			~		symbols			n times: symbol (8 bits) and, but for the last symbol, its frequency (w bits)
+
+Frequencies of rANS records sum up to 4096, thus the frequency of the last symbol is not stored.
+
+The payload of a file with arithmetic (rANS) records is encoded by a single arithmetic (rANS)
+coder, thus Huffman (or index), arithmetic and rANS records can not be mixed in the same file.
+The rANS encoding of the payload is made of chunks of 65536 symbols (the last one may be shorter),
+each chunk starting with the final state of the coder (4 bytes, little endian) and ending when
+the decoder state is back to its initial value.
+
+# Version 3 header (read only)
+Position	Size 	What 		 		Example/Comment
+0        	9    	magic      			\211 N E X T \r \n \032 \n
+9			1		version number		3
+10			2		data offset			120
+12			1		model				0
+13			1		context order		2
+14			8		original length 	25487852
+22			4		trans recods count	number of transition records in this file
+26			r		root				the first r bytes of the content
+26+r		1		chksum				addition (overflowed) of previous bytes
+
+The trailer of version 3 files is the content CRC (4 bytes, little endian) following the payload.
+
+# Version 2 (read only)
+Version 2 files are version 3 files without the trailer.
+
+# Version 1 header (read only)
+Position	Size 	What 		 		Example/Comment
+0        	9    	magic      			\211 N E X T \r \n \032 \n
+9			1		version number		1
+10			2		data offset			27
+12			1		context order		2
+13			8		original length 	25487852
+21			4		trans recods count	number of transition records in this file
+25			r		root				the first r = min(context order, original length) bytes
+25+r		1		chksum				addition (overflowed) of previous bytes
+
+Version 1 files use the transducer model.
+
+# Version 0 header (read only)
+Position	Size 	What 		 		Example/Comment
+0        	9    	magic      			\211 N E X T \r \n \032 \n
+9			1		version number		0
+10			2		data offset			23
+12			1		root byte			65
+13			8		original length 	25487852
+21			1		trans recods count	number of transition records in this file
+22			1		chksum				addition (overflowed) of previous bytes
+
+Version 0 files use the transducer model with a context order of 1.
+
+# Framed files (version 5)
+The content is cut into blocks compressed independently of each other: each block is
+a version 4 file (with its own header, trans records, payload and trailer) held in a frame.
+Frames let the blocks be decoded in parallel, and a damaged block be skipped.
+
+Position	Size 	What 		 		Example/Comment
+0        	9    	magic      			\211 N E X T \r \n \032 \n
+9			1		version number		5
+10			2		data offset			17 (position of the first frame)
+12			4		block size			1048576 (maximum length of the content of a block)
+16			e		extensions			optional fields (see extensions above)
+16+e		1		chksum				addition (overflowed) of previous bytes
+xx			x		frames				at the data offset
+
+## Frame
+Position	Size 	What 		 		Example/Comment
+0        	4    	marker      		N X T F
+4			4		block length		length of the compressed block
+8			4		content length		length of the content of the block
+12			1		chksum				addition (overflowed) of previous bytes of the frame header
+13			b		block				version 4 file of the content of the block (b = block length)
+
+The last frame is an empty frame (block and content lengths of 0), a file without it is truncated.
+The marker lets a reader find the next frame after a damaged frame header.
+
+## Index
+The frames are followed by the index of the blocks, to read the content from any offset.
+
+Position	Size 	What 		 		Example/Comment
+0        	4    	marker      		N X T I
+4			4		blocks count		n
+8			16n		blocks				n times: offset of the content of the block (8 bytes, little endian)
+										and offset of its frame, relative to the start of the file (8 bytes, little endian)
+8+16n		8		content length		length of the whole content
+16+16n		4		index CRC			CRC-32 (IEEE) of the previous bytes of the index, little endian
+20+16n		8		index offset		position of the index, relative to the start of the file (last 8 bytes of the file)
+
 */
 
 // magic \211 N E X T \r \n \032 \n
 var magic = []byte{137, 78, 69, 88, 84, 13, 10, 26, 10}
 
-const versionNumber = uint8(0)
+// versionNumber is the latest format version, the version of framed files
+const versionNumber = uint8(5)
+
+// streamVersionNumber is the format version of single stream (not framed) files
+const streamVersionNumber = uint8(4)
 
 type length uint64
 type offset uint16
@@ -56,17 +250,100 @@ type frequency uint64
 // Next List
 const recordTypeNextList = recordType(0)
 
-func WriteHeader(w io.Writer, rootSymbol byte, inputSize types.Size, symbolCount byte) {
-	const headerSize = 22
-	const offset = offset(headerSize + 1)
+// Model identifies how the transitions of a compressed file are modeled
+type Model uint8
+
+// Models
+const (
+	ModelTransducer = Model(0)
+	ModelBlended    = Model(1)
+	ModelAdaptive   = Model(2)
+)
+
+// Header extension tags
+const (
+	extensionName       = 1
+	extensionModTime    = 2
+	extensionMode       = 3
+	extensionComment    = 4
+	extensionParameters = 5
+)
+
+// extensionLengths are the lengths of the values of the fixed length extensions
+var extensionLengths = map[byte]int{extensionModTime: 8, extensionMode: 4, extensionParameters: 2}
+
+// trailerSize is the number of bytes of the trailer: the original length and the CRC of the content
+const trailerSize = 12
+
+// headerFlagUnknownSize is set in the flags of a header without the original length
+const headerFlagUnknownSize = 1
+
+// recordFlagEscape is set in the record type of blended model records having an escape symbol
+const recordFlagEscape = recordType(128)
+
+// Header represents the header of a compressed file
+type Header struct {
+	Version     uint8  // format version of a read header, headers are written with version 4 (5 if framed)
+	BlockSize   uint32 // maximum content length of the blocks of a framed file, 0 for a single stream file
+	Model       Model
+	Order       byte
+	Root        []byte
+	InputSize   types.Size
+	UnknownSize bool // the original length is not in the header, InputSize is meaningless
+	RecordCount uint32
+	Metadata    Metadata
+}
+
+// Metadata is the optional information about the original content, stored in the header extensions
+type Metadata struct {
+	Name       string      // file name, empty if unknown
+	ModTime    time.Time   // modification time, zero if unknown
+	Mode       os.FileMode // permission and mode bits, 0 if unknown
+	Comment    string
+	Parameters *Parameters // nil if unknown
+}
+
+// Parameters are the compression parameters of a file, beyond its model and context order
+type Parameters struct {
+	Coder         Coder
+	MaxCodeLength uint8
+}
+
+// WriteHeader writes the given header in the given writer, the header of a framed file if its block size is set.
+// Only the block size and the metadata of framed file headers are written.
+// Error will arise if the header can not be written, if the length of a transducer model file is unknown
+// or if the metadata make the header longer than the largest data offset (65535 bytes)
+func WriteHeader(w io.Writer, h Header) error {
+	var header []interface{}
+	if h.BlockSize > 0 {
+		header = []interface{}{
+			magic,
+			versionNumber,
+			offset(0), // set once the header size is known
+			h.BlockSize,
+			extensions(h.Metadata),
+		}
+	} else {
+		var flags uint8
+		if h.UnknownSize {
+			if h.Model == ModelTransducer {
+				return errors.New("the original length of a transducer model file must be known")
+			}
+			flags |= headerFlagUnknownSize
+		}
 
-	var header = []interface{}{
-		magic,
-		versionNumber,
-		offset,
-		rootSymbol,
-		inputSize,
-		symbolCount,
+		header = []interface{}{
+			magic,
+			streamVersionNumber,
+			offset(0), // set once the header size is known
+			h.Model,
+			h.Order,
+			flags,
+		}
+		if !h.UnknownSize {
+			header = append(header, h.InputSize)
+		}
+		header = append(header, h.RecordCount, h.Root, extensions(h.Metadata))
 	}
 
 	buf := new(bytes.Buffer)
@@ -76,9 +353,10 @@ func WriteHeader(w io.Writer, rootSymbol byte, inputSize types.Size, symbolCount
 			panic(fmt.Sprintf("failed to write header: %v", err))
 		}
 	}
-	if buf.Len() != headerSize {
-		panic(fmt.Sprintf("bad header size %v, expected %v\nheader:%+v", buf.Len(), headerSize, buf.Bytes()))
+	if buf.Len()+1 > math.MaxUint16 {
+		return fmt.Errorf("header of %d bytes, longer than %d bytes: the name or comment is too long", buf.Len()+1, math.MaxUint16)
 	}
+	binary.LittleEndian.PutUint16(buf.Bytes()[len(magic)+1:], uint16(buf.Len()+1))
 
 	checksum := checksum(buf.Bytes())
 	err := binary.Write(buf, binary.LittleEndian, checksum)
@@ -86,72 +364,340 @@ func WriteHeader(w io.Writer, rootSymbol byte, inputSize types.Size, symbolCount
 		panic(fmt.Sprintf("failed to write header's checksum: %v", err))
 	}
 
-	w.Write(buf.Bytes())
+	_, err = w.Write(buf.Bytes())
+
+	return err
 }
 
-func ReadHeader(r io.Reader) (rootSymbol uint8, inputSize types.Size, symbolCount byte, err error) {
+// ErrUnsupportedVersion is the error of reading a file of an unknown format version
+var ErrUnsupportedVersion = errors.New("unsupported version number")
+
+// ReadHeader reads a header from the given reader.
+// Header fields unknown to the reader, between the known fields and the checksum, are skipped.
+// Since version 4 those fields are extensions, they are read as the header metadata
+func ReadHeader(r io.Reader) (Header, error) {
 	mgc := make([]byte, len(magic))
-	l, err := r.Read(mgc)
+	l, err := io.ReadFull(r, mgc)
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
 	}
 	if l != len(magic) {
-		return 0, 0, 0, fmt.Errorf("expected to read %d bytes of magic file header, got %d", len(magic), l)
+		return Header{}, fmt.Errorf("expected to read %d bytes of magic file header, got %d", len(magic), l)
 	}
 	if !reflect.DeepEqual(magic, mgc) {
-		return 0, 0, 0, fmt.Errorf("expected to magic file header to be\n\t%v\ngot\n\t%v", magic, mgc)
+		return Header{}, fmt.Errorf("expected to magic file header to be\n\t%v\ngot\n\t%v", magic, mgc)
 	}
 
 	//version number
-	vn := make([]byte, 1)
-	_, err = r.Read(vn)
+	var vn uint8
+	err = binary.Read(r, binary.LittleEndian, &vn)
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
+	}
+	if vn > versionNumber {
+		return Header{}, fmt.Errorf("%w %d, the file was written by a newer version of next (latest known version is %d)", ErrUnsupportedVersion, vn, versionNumber)
 	}
 
-	//records offset
-	ro := make([]byte, 2)
-	_, err = r.Read(ro)
+	cr := &checksumReader{r: r, sum: checksum(mgc) + vn}
+	var dataOffset offset
+	err = binary.Read(cr, binary.LittleEndian, &dataOffset)
+	if err != nil {
+		return Header{}, err
+	}
+
+	var h Header
+	switch vn {
+	case 0:
+		h, err = readHeaderV0(cr)
+	case 1:
+		h, err = readHeaderV1(cr)
+	case 2, 3:
+		h, err = readHeaderV2(cr)
+	case 4:
+		h, err = readHeaderV4(cr)
+	default:
+		h, err = readHeaderV5(cr)
+	}
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
 	}
+	h.Version = vn
 
-	//root symbol
-	rs := make([]byte, 1)
-	_, err = r.Read(rs)
+	// skip the fields unknown to this version of the reader, up to the checksum
+	read := len(mgc) + 1 + cr.n
+	if int(dataOffset) < read+1 {
+		return Header{}, fmt.Errorf("data offset %d inside the %d bytes of the header", dataOffset, read+1)
+	}
+	unknownFields := make([]byte, int(dataOffset)-read-1)
+	_, err = io.ReadFull(cr, unknownFields)
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
 	}
-	rootSymbol = rs[0]
 
-	//input length
-	il := make([]byte, 8)
-	_, err = r.Read(il)
+	want := cr.sum
+	var cs byte
+	err = binary.Read(r, binary.LittleEndian, &cs)
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
+	}
+	if cs != want {
+		return Header{}, IntegrityError{What: "header", Expected: uint32(cs), Actual: uint32(want)}
+	}
+
+	if vn >= 4 {
+		h.Metadata, err = readExtensions(unknownFields)
+		if err != nil {
+			return Header{}, err
+		}
 	}
 
-	inputSize = types.Size(binary.LittleEndian.Uint64(il))
+	return h, nil
+}
 
-	//symbol count
-	sc := make([]byte, 1)
-	_, err = r.Read(sc)
+func readHeaderV0(r io.Reader) (Header, error) {
+	var fields struct {
+		Root        byte
+		InputSize   types.Size
+		RecordCount byte
+	}
+	err := binary.Read(r, binary.LittleEndian, &fields)
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
 	}
 
-	symbolCount = sc[0]
+	root := []byte{}
+	if fields.InputSize > 0 {
+		root = append(root, fields.Root)
+	}
 
-	//root symbol
-	cs := make([]byte, 1)
-	_, err = r.Read(cs)
+	return Header{
+		Model:       ModelTransducer,
+		Order:       1,
+		Root:        root,
+		InputSize:   fields.InputSize,
+		RecordCount: uint32(fields.RecordCount),
+	}, nil
+}
+
+func readHeaderV1(r io.Reader) (Header, error) {
+	var fields struct {
+		Order       byte
+		InputSize   types.Size
+		RecordCount uint32
+	}
+	err := binary.Read(r, binary.LittleEndian, &fields)
+	if err != nil {
+		return Header{}, err
+	}
+
+	rootSize := types.Size(fields.Order)
+	if fields.InputSize < rootSize {
+		rootSize = fields.InputSize
+	}
+	root := make([]byte, rootSize)
+	_, err = io.ReadFull(r, root)
+	if err != nil {
+		return Header{}, err
+	}
+
+	return Header{
+		Model:       ModelTransducer,
+		Order:       fields.Order,
+		Root:        root,
+		InputSize:   fields.InputSize,
+		RecordCount: fields.RecordCount,
+	}, nil
+}
+
+func readHeaderV2(r io.Reader) (Header, error) {
+	var fields struct {
+		Model       Model
+		Order       byte
+		InputSize   types.Size
+		RecordCount uint32
+	}
+	err := binary.Read(r, binary.LittleEndian, &fields)
+	if err != nil {
+		return Header{}, err
+	}
+
+	var rootSize types.Size
+	switch fields.Model {
+	case ModelTransducer:
+		rootSize = types.Size(fields.Order)
+		if fields.InputSize < rootSize {
+			rootSize = fields.InputSize
+		}
+	case ModelBlended, ModelAdaptive:
+		rootSize = 0
+	default:
+		return Header{}, fmt.Errorf("unknown model %d", fields.Model)
+	}
+
+	root := make([]byte, rootSize)
+	_, err = io.ReadFull(r, root)
+	if err != nil {
+		return Header{}, err
+	}
+
+	return Header{
+		Model:       fields.Model,
+		Order:       fields.Order,
+		Root:        root,
+		InputSize:   fields.InputSize,
+		RecordCount: fields.RecordCount,
+	}, nil
+}
+
+func readHeaderV4(r io.Reader) (Header, error) {
+	var fields struct {
+		Model Model
+		Order byte
+		Flags uint8
+	}
+	err := binary.Read(r, binary.LittleEndian, &fields)
+	if err != nil {
+		return Header{}, err
+	}
+
+	unknownSize := fields.Flags&headerFlagUnknownSize != 0
+	var inputSize types.Size
+	if !unknownSize {
+		err = binary.Read(r, binary.LittleEndian, &inputSize)
+		if err != nil {
+			return Header{}, err
+		}
+	}
+
+	var recordCount uint32
+	err = binary.Read(r, binary.LittleEndian, &recordCount)
+	if err != nil {
+		return Header{}, err
+	}
+
+	var rootSize types.Size
+	switch fields.Model {
+	case ModelTransducer:
+		if unknownSize {
+			return Header{}, errors.New("unknown original length of a transducer model file")
+		}
+		rootSize = types.Size(fields.Order)
+		if inputSize < rootSize {
+			rootSize = inputSize
+		}
+	case ModelBlended, ModelAdaptive:
+		rootSize = 0
+	default:
+		return Header{}, fmt.Errorf("unknown model %d", fields.Model)
+	}
+
+	root := make([]byte, rootSize)
+	_, err = io.ReadFull(r, root)
+	if err != nil {
+		return Header{}, err
+	}
+
+	return Header{
+		Model:       fields.Model,
+		Order:       fields.Order,
+		Root:        root,
+		InputSize:   inputSize,
+		UnknownSize: unknownSize,
+		RecordCount: recordCount,
+	}, nil
+}
+
+func readHeaderV5(r io.Reader) (Header, error) {
+	var blockSize uint32
+	err := binary.Read(r, binary.LittleEndian, &blockSize)
 	if err != nil {
-		return 0, 0, 0, err
+		return Header{}, err
+	}
+	if blockSize == 0 {
+		return Header{}, errors.New("block size of 0 in the header of a framed file")
+	}
+
+	return Header{BlockSize: blockSize}, nil
+}
+
+// extensions yields the header extensions of the given metadata
+func extensions(m Metadata) []byte {
+	bs := bitstream.New()
+	add := func(tag byte, value []byte) {
+		bs.WriteBits(uint64(tag), 8)
+		bitstream.WriteUvarint(&bs, uint64(len(value)))
+		for _, b := range value {
+			bs.WriteBits(uint64(b), 8)
+		}
+	}
+
+	if m.Name != "" {
+		add(extensionName, []byte(m.Name))
+	}
+	if !m.ModTime.IsZero() {
+		var v [8]byte
+		binary.LittleEndian.PutUint64(v[:], uint64(m.ModTime.UnixNano()))
+		add(extensionModTime, v[:])
+	}
+	if m.Mode != 0 {
+		var v [4]byte
+		binary.LittleEndian.PutUint32(v[:], uint32(m.Mode))
+		add(extensionMode, v[:])
+	}
+	if m.Comment != "" {
+		add(extensionComment, []byte(m.Comment))
+	}
+	if m.Parameters != nil {
+		add(extensionParameters, []byte{byte(m.Parameters.Coder), m.Parameters.MaxCodeLength})
 	}
 
-	// TODO check sum
+	return bs.Bytes()
+}
+
+// readExtensions yields the metadata in the given header extensions, extensions of unknown tags are skipped.
+// Error will arise if the extensions are malformed
+func readExtensions(data []byte) (Metadata, error) {
+	var result Metadata
+	r := bitstream.NewReader(bytes.NewReader(data))
+	for {
+		if _, available := r.Peek(8); available == 0 {
+			break
+		}
+		tag, err := r.ReadByte()
+		if err != nil {
+			return Metadata{}, err
+		}
+		l, err := bitstream.ReadUvarint(r)
+		if err != nil || l > uint64(len(data)) {
+			return Metadata{}, fmt.Errorf("malformed header extension of tag %d", tag)
+		}
+		value := make([]byte, l)
+		for i := range value {
+			value[i], err = r.ReadByte()
+			if err != nil {
+				return Metadata{}, fmt.Errorf("malformed header extension of tag %d", tag)
+			}
+		}
+
+		if want, fixed := extensionLengths[tag]; fixed && len(value) != want {
+			return Metadata{}, fmt.Errorf("header extension of tag %d is %d bytes long, expected %d", tag, len(value), want)
+		}
+
+		switch tag {
+		case extensionName:
+			result.Name = string(value)
+		case extensionModTime:
+			result.ModTime = time.Unix(0, int64(binary.LittleEndian.Uint64(value)))
+		case extensionMode:
+			result.Mode = os.FileMode(binary.LittleEndian.Uint32(value))
+		case extensionComment:
+			result.Comment = string(value)
+		case extensionParameters:
+			result.Parameters = &Parameters{Coder: Coder(value[0]), MaxCodeLength: value[1]}
+		}
+	}
 
-	return rootSymbol, inputSize, symbolCount, nil
+	return result, nil
 }
 
 func checksum(bs []byte) byte {
@@ -162,3 +708,39 @@ func checksum(bs []byte) byte {
 
 	return result
 }
+
+// checksumReader is a reader summing up (overflowed) the bytes it reads
+type checksumReader struct {
+	r   io.Reader
+	sum byte
+	n   int // number of read bytes
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.sum += checksum(p[:n])
+	cr.n += n
+
+	return n, err
+}
+
+// IntegrityError is the error of a compressed file whose checksum does not match its content
+// or whose content can not be decoded
+type IntegrityError struct {
+	What     string // checked part of the file: header or content
+	Expected uint32 // checksum stored in the file
+	Actual   uint32 // checksum of the read (or decoded) data
+	Err      error  // error decoding the corrupted data, if any, the checksums are then meaningless
+}
+
+func (e IntegrityError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("corrupted %s: %v", e.What, e.Err)
+	}
+
+	return fmt.Sprintf("corrupted %s: checksum %#x, expected %#x", e.What, e.Actual, e.Expected)
+}
+
+func (e IntegrityError) Unwrap() error {
+	return e.Err
+}
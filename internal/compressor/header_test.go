@@ -2,36 +2,41 @@ package compressor
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
-
-	"github.com/chavacava/next/internal/types"
+	"time"
 )
 
 func TestWriteHeader(t *testing.T) {
 	tt := map[string]struct {
-		root        byte
-		size        types.Size
-		symbolCount byte
-		want        []byte
+		header Header
+		want   []byte
 	}{
 		"empty content": {
-			root:        0,
-			size:        0,
-			symbolCount: 0,
-			want:        []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 0, 23, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 26},
+			header: Header{Model: ModelTransducer, Order: 1, Root: []byte{}, InputSize: 0, RecordCount: 0},
+			want:   []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 4, 28, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 36},
 		},
 		"1 byte content length": {
-			root:        0,
-			size:        1,
-			symbolCount: 1,
-			want:        []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 0, 23, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 28},
+			header: Header{Model: ModelTransducer, Order: 1, Root: []byte{65}, InputSize: 1, RecordCount: 0},
+			want:   []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 4, 29, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 65, 103},
+		},
+		"1000 bytes content length order 2": {
+			header: Header{Model: ModelTransducer, Order: 2, Root: []byte{255, 0}, InputSize: 1000, RecordCount: 300},
+			want:   []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 4, 30, 0, 0, 2, 0, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 255, 0, 62},
+		},
+		"1000 bytes content length blended order 3": {
+			header: Header{Model: ModelBlended, Order: 3, Root: []byte{}, InputSize: 1000, RecordCount: 300},
+			want:   []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 4, 28, 0, 1, 3, 0, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 63},
+		},
+		"unknown content length adaptive order 2": {
+			header: Header{Model: ModelAdaptive, Order: 2, Root: []byte{}, UnknownSize: true, RecordCount: 0},
+			want:   []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 4, 20, 0, 2, 2, 1, 0, 0, 0, 0, 32},
 		},
-		"1000 bytes content length root 255": {
-			root:        255,
-			size:        1000,
-			symbolCount: 3,
-			want:        []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 0, 23, 0, 255, 232, 3, 0, 0, 0, 0, 0, 0, 3, 7},
+		"framed block size 64": {
+			header: Header{BlockSize: 64},
+			want:   []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 5, 17, 0, 64, 0, 0, 0, 89},
 		},
 	}
 
@@ -39,12 +44,235 @@ func TestWriteHeader(t *testing.T) {
 		t.Run(name,
 			func(t *testing.T) {
 				got := new(bytes.Buffer)
-				WriteHeader(got, tc.root, tc.size, tc.symbolCount)
+				WriteHeader(got, tc.header)
 				if !reflect.DeepEqual(tc.want, got.Bytes()) {
 					t.Fatalf("expected\n\t%v\ngot\n\t%v", tc.want, got.Bytes())
 				}
+
+				read, err := ReadHeader(got)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				want := tc.header
+				want.Version = streamVersionNumber
+				if want.BlockSize > 0 {
+					want.Version = versionNumber
+				}
+				if !reflect.DeepEqual(want, read) {
+					t.Fatalf("expected to read\n\t%+v\ngot\n\t%+v", want, read)
+				}
+			},
+		)
+	}
+
+}
+
+func TestWriteHeaderTransducerUnknownSize(t *testing.T) {
+	err := WriteHeader(new(bytes.Buffer), Header{Model: ModelTransducer, Order: 1, Root: []byte{65}, UnknownSize: true})
+	if err == nil {
+		t.Fatal("error expected writing a transducer model header without the original length")
+	}
+}
+
+func TestWriteHeaderTooLong(t *testing.T) {
+	long := Metadata{Comment: strings.Repeat("c", 1<<16)}
+	tt := map[string]Header{
+		"stream": {Model: ModelBlended, Order: 2, Root: []byte{}, Metadata: long},
+		"framed": {BlockSize: 64, Metadata: long},
+	}
+
+	for name, h := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				buf := new(bytes.Buffer)
+				if err := WriteHeader(buf, h); err == nil {
+					t.Fatal("error expected writing a header longer than 65535 bytes")
+				}
+				if buf.Len() != 0 {
+					t.Fatalf("expected nothing written, got %d bytes", buf.Len())
+				}
+			},
+		)
+	}
+}
+
+func TestReadHeaderOldVersions(t *testing.T) {
+	tt := map[string]struct {
+		header []byte
+		want   Header
+	}{
+		"version 0": {
+			header: []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 0, 23, 0, 255, 232, 3, 0, 0, 0, 0, 0, 0, 3, 7},
+			want:   Header{Version: 0, Model: ModelTransducer, Order: 1, Root: []byte{255}, InputSize: 1000, RecordCount: 3},
+		},
+		"version 1": {
+			header: []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 1, 28, 0, 2, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 255, 0, 57},
+			want:   Header{Version: 1, Model: ModelTransducer, Order: 2, Root: []byte{255, 0}, InputSize: 1000, RecordCount: 300},
+		},
+		"version 2": {
+			header: []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 2, 27, 0, 1, 3, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 60},
+			want:   Header{Version: 2, Model: ModelBlended, Order: 3, Root: []byte{}, InputSize: 1000, RecordCount: 300},
+		},
+		"version 3": {
+			header: []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 3, 29, 0, 0, 2, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 255, 0, 60},
+			want:   Header{Version: 3, Model: ModelTransducer, Order: 2, Root: []byte{255, 0}, InputSize: 1000, RecordCount: 300},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				got, err := ReadHeader(bytes.NewReader(tc.header))
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if !reflect.DeepEqual(tc.want, got) {
+					t.Fatalf("expected\n\t%+v\ngot\n\t%+v", tc.want, got)
+				}
+			},
+		)
+	}
+}
+
+func TestReadHeaderChecksum(t *testing.T) {
+	tt := map[string][]byte{
+		"version 0": {137, 78, 69, 88, 84, 13, 10, 26, 10, 0, 23, 0, 255, 232, 3, 0, 0, 0, 0, 0, 0, 3, 8},
+		"version 1": {137, 78, 69, 88, 84, 13, 10, 26, 10, 1, 28, 0, 2, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 255, 1, 57},
+		"version 3": {137, 78, 69, 88, 84, 13, 10, 26, 10, 3, 27, 0, 1, 3, 232, 3, 0, 0, 0, 0, 0, 1, 44, 1, 0, 0, 61},
+	}
+
+	for name, header := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				_, err := ReadHeader(bytes.NewReader(header))
+				var ie IntegrityError
+				if !errors.As(err, &ie) {
+					t.Fatalf("expected an integrity error, got %v", err)
+				}
 			},
 		)
 	}
+}
+
+func TestReadHeaderVersionAndOffset(t *testing.T) {
+	tt := map[string]struct {
+		header  []byte
+		want    Header
+		wantErr error
+	}{
+		"unknown future version": {
+			header:  []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, versionNumber + 1, 27, 0, 1, 3, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 62},
+			wantErr: ErrUnsupportedVersion,
+		},
+		"unknown fields": {
+			header: []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 3, 30, 0, 1, 3, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 1, 2, 3, 70, 255},
+			want:   Header{Version: 3, Model: ModelBlended, Order: 3, Root: []byte{}, InputSize: 1000, RecordCount: 300},
+		},
+		"data offset inside the header": {
+			header:  []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 3, 20, 0, 1, 3, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0, 54},
+			wantErr: errors.New("data offset 20 inside the 27 bytes of the header"),
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				r := bytes.NewReader(tc.header)
+				got, err := ReadHeader(r)
+				if tc.wantErr != nil {
+					if err == nil || !errors.Is(err, tc.wantErr) && err.Error() != tc.wantErr.Error() {
+						t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if !reflect.DeepEqual(tc.want, got) {
+					t.Fatalf("expected\n\t%+v\ngot\n\t%+v", tc.want, got)
+				}
+				if r.Len() != 1 {
+					t.Fatalf("expected to stop reading at the data offset, %d bytes left", r.Len())
+				}
+			},
+		)
+	}
+}
+
+func TestHeaderExtensions(t *testing.T) {
+	metadata := Metadata{
+		Name:       "notes.txt",
+		ModTime:    time.Unix(0, 1234567890),
+		Mode:       0600,
+		Comment:    "with ünicode",
+		Parameters: &Parameters{Coder: CoderRANS, MaxCodeLength: 12},
+	}
+	h := Header{Version: streamVersionNumber, Model: ModelBlended, Order: 3, Root: []byte{}, InputSize: 1000, RecordCount: 300, Metadata: metadata}
 
+	buf := new(bytes.Buffer)
+	err := WriteHeader(buf, h)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	got, err := ReadHeader(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(h, got) {
+		t.Fatalf("expected\n\t%+v\ngot\n\t%+v", h, got)
+	}
+
+	fixed := []byte{137, 78, 69, 88, 84, 13, 10, 26, 10, 4, 0, 0, 1, 3, 0, 232, 3, 0, 0, 0, 0, 0, 0, 44, 1, 0, 0}
+	tt := map[string]struct {
+		extensions []byte
+		want       Metadata
+		wantErr    bool
+	}{
+		"unknown tag": {
+			extensions: []byte{99, 3, 1, 2, 3, extensionName, 1, 'a'},
+			want:       Metadata{Name: "a"},
+		},
+		"truncated value": {
+			extensions: []byte{extensionComment, 5, 'a'},
+			wantErr:    true,
+		},
+		"bad fixed length": {
+			extensions: []byte{extensionMode, 2, 1, 2},
+			wantErr:    true,
+		},
+		"two bytes length": {
+			extensions: append([]byte{extensionComment, 0x81, 1}, bytes.Repeat([]byte{'c'}, 129)...),
+			want:       Metadata{Comment: strings.Repeat("c", 129)},
+		},
+		"truncated length": {
+			extensions: []byte{extensionComment, 0x81},
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				header := append(append([]byte{}, fixed...), tc.extensions...)
+				header[10] = byte(len(header) + 1)
+				header = append(header, checksum(header))
+
+				got, err := ReadHeader(bytes.NewReader(header))
+				if tc.wantErr {
+					if err == nil {
+						t.Fatal("error expected")
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if !reflect.DeepEqual(tc.want, got.Metadata) {
+					t.Fatalf("expected\n\t%+v\ngot\n\t%+v", tc.want, got.Metadata)
+				}
+			},
+		)
+	}
 }
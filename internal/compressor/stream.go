@@ -0,0 +1,117 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/chavacava/next/internal/table"
+)
+
+// maxMemorySpool is the number of bytes of a non seekable input held in memory,
+// longer inputs are spooled to a temporary file
+var maxMemorySpool int64 = 32 << 20
+
+// StreamCompressor represents a compressor of the content of any reader in a single stream file,
+// the transitions table being built from the content.
+// Use the constructor to create new instances
+type StreamCompressor struct {
+	model    Model
+	order    int
+	coder    Coder
+	metadata Metadata
+}
+
+// NewStreamCompressor yields a new compressor with the given model and context order
+// that encodes transitions with the given coder
+func NewStreamCompressor(model Model, order int, coder Coder) StreamCompressor {
+	return StreamCompressor{model: model, order: order, coder: coder}
+}
+
+// WithMetadata yields a copy of this compressor that stores the given metadata in the header of the compressed files.
+// The compression parameters of the metadata are set by the compressor
+func (c StreamCompressor) WithMetadata(m Metadata) StreamCompressor {
+	c.metadata = m
+	return c
+}
+
+// Compress compresses the content from input and writes the result in the given writer.
+// The transducer and blended models read the content twice, to build the transitions table then to encode it:
+// if the input is not seekable its content is spooled, in memory then in a temporary file if it is long.
+// The adaptive model reads the input once
+func (c StreamCompressor) Compress(input io.Reader, w io.Writer) error {
+	var newTable func(io.ReadSeeker, int) table.TransitionsTable
+	switch c.model {
+	case ModelAdaptive:
+		return NewCompressorWithCoder(table.NewAdaptive(c.order), c.coder).WithMetadata(c.metadata).Compress(input, w)
+	case ModelTransducer:
+		newTable = table.New
+	case ModelBlended:
+		newTable = table.NewBlended
+	default:
+		return fmt.Errorf("unknown model %d", c.model)
+	}
+
+	rs, release, err := spool(input)
+	if err != nil {
+		return fmt.Errorf("unable to spool the input: %v", err)
+	}
+	defer release()
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	tt := newTable(rs, c.order)
+	_, err = rs.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return NewCompressorWithCoder(tt, c.coder).WithMetadata(c.metadata).Compress(rs, w)
+}
+
+// spool yields a seekable reader of the content of input: input itself if it is seekable, otherwise its
+// content held in memory up to maxMemorySpool bytes, then in a temporary file removed by release
+func spool(input io.Reader) (rs io.ReadSeeker, release func(), err error) {
+	release = func() {}
+	if s, ok := input.(io.ReadSeeker); ok {
+		if _, err := s.Seek(0, io.SeekCurrent); err == nil {
+			return s, release, nil
+		}
+	}
+
+	head := new(bytes.Buffer)
+	_, err = io.CopyN(head, input, maxMemorySpool+1)
+	if err == io.EOF {
+		return bytes.NewReader(head.Bytes()), release, nil
+	}
+	if err != nil {
+		return nil, release, err
+	}
+
+	f, err := ioutil.TempFile("", "next-spool-")
+	if err != nil {
+		return nil, release, err
+	}
+	release = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	_, err = f.Write(head.Bytes())
+	if err == nil {
+		_, err = io.Copy(f, input)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		return nil, func() {}, err
+	}
+
+	return f, release, nil
+}
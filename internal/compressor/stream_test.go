@@ -0,0 +1,88 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// pipe hides the Seek method of the underlying reader, as a pipe does
+type pipe struct {
+	io.Reader
+}
+
+func TestStreamCompressorNonSeekable(t *testing.T) {
+	input := strings.Repeat("Simplicity is prerequisite for reliability. ", 20)
+
+	// spooled inputs longer than 100 bytes go to a temporary file
+	defer func(n int64) { maxMemorySpool = n }(maxMemorySpool)
+	maxMemorySpool = 100
+	tempDir, err := ioutil.TempDir("", "next-test-")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tempDir)
+
+	tt := map[string]string{
+		"empty":          "",
+		"held in memory": input[:100],
+		"temporary file": input,
+	}
+
+	for name, input := range tt {
+		for _, model := range []Model{ModelTransducer, ModelBlended, ModelAdaptive} {
+			input, model := input, model
+			t.Run(fmt.Sprintf("%s model %d", name, model),
+				func(t *testing.T) {
+					c := NewStreamCompressor(model, 2, CoderHuffman)
+					want := new(bytes.Buffer)
+					err := c.Compress(strings.NewReader(input), want)
+					if err != nil {
+						t.Fatalf("unexpected compression error %v", err)
+					}
+
+					got := new(bytes.Buffer)
+					err = c.Compress(pipe{strings.NewReader(input)}, got)
+					if err != nil {
+						t.Fatalf("unexpected compression error %v", err)
+					}
+
+					header, err := ReadHeader(bytes.NewReader(got.Bytes()))
+					if err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+					// but for the adaptive model, the size of a spooled input is known
+					if model != ModelAdaptive && !bytes.Equal(want.Bytes(), got.Bytes()) {
+						t.Fatal("expected the same compression of seekable and non seekable inputs")
+					}
+					if model == ModelAdaptive && !header.UnknownSize {
+						t.Fatal("expected an unknown size for the adaptive model")
+					}
+
+					decompressed := new(bytes.Buffer)
+					err = NewDecompressor().Decompress(got, decompressed)
+					if err != nil || decompressed.String() != input {
+						t.Fatalf("expected to decompress the input, got %q, %v", decompressed.String(), err)
+					}
+
+					if spooled, _ := ioutil.ReadDir(tempDir); len(spooled) != 0 {
+						t.Fatalf("expected the temporary files to be removed, got %v", spooled)
+					}
+				},
+			)
+		}
+	}
+}
+
+func TestStreamCompressorUnknownModel(t *testing.T) {
+	err := NewStreamCompressor(Model(9), 2, CoderHuffman).Compress(strings.NewReader("abc"), new(bytes.Buffer))
+	if err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -0,0 +1,269 @@
+package huffman
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/chavacava/next/internal/bitstream"
+)
+
+// codeLengthWidthBits is the number of bits of the field holding the bit width of code lengths (minus one)
+const codeLengthWidthBits = 3
+
+// riceParameterBits is the number of bits of the field holding the Rice parameter of symbol differences
+const riceParameterBits = 3
+
+// maxCodeLength is the length of the longest supported code
+const maxCodeLength = 63
+
+// SymbolLength represents a symbol and the length of its code
+type SymbolLength struct {
+	Symbol byte
+	Length int
+}
+
+// NewCanonicalTree yields a tree having the canonical Huffman code corresponding to the given list of symbol frequencies.
+// Codes of a canonical tree only depend on their lengths, thus the tree can be rebuilt from its symbols and code lengths
+func NewCanonicalTree(fs []SymbolFreq) Tree {
+	result, err := NewTreeFromCodeLengths(NewTree(fs).CodeLengths())
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+// CodeLengths yields the symbols of this tree with the length of their codes, sorted by symbol
+func (t Tree) CodeLengths() []SymbolLength {
+	result := []SymbolLength{}
+	codeLengths(t.root, 0, &result)
+	sort.Slice(result, func(i, j int) bool { return result[i].Symbol < result[j].Symbol })
+
+	return result
+}
+
+func codeLengths(n node, depth int, result *[]SymbolLength) {
+	switch nt := n.(type) {
+	case intNode:
+		codeLengths(nt.left, depth+1, result)
+		codeLengths(nt.right, depth+1, result)
+	case SymbolFreq:
+		*result = append(*result, SymbolLength{Symbol: nt.Symbol, Length: depth})
+	default:
+		panic(fmt.Sprintf("unknown Huffman tree node type %t", nt))
+	}
+}
+
+// NewTreeFromCodeLengths yields the canonical Huffman tree of the given symbols and code lengths.
+// Codes are assigned in increasing order of code length, then of symbol.
+// Error will arise if the code lengths do not define a complete prefix code
+func NewTreeFromCodeLengths(lengths []SymbolLength) (Tree, error) {
+	if len(lengths) == 0 {
+		return Tree{}, errors.New("no symbols to build a Huffman tree")
+	}
+
+	sorted := make([]SymbolLength, len(lengths))
+	copy(sorted, lengths)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Length != sorted[j].Length {
+			return sorted[i].Length < sorted[j].Length
+		}
+		return sorted[i].Symbol < sorted[j].Symbol
+	})
+
+	codes := make([]canonicalCode, len(sorted))
+	code := uint64(0)
+	for i, sl := range sorted {
+		if sl.Length > maxCodeLength {
+			return Tree{}, fmt.Errorf("code length %d of symbol %v longer than %d", sl.Length, sl.Symbol, maxCodeLength)
+		}
+		if i > 0 {
+			if sl.Symbol == sorted[i-1].Symbol {
+				return Tree{}, fmt.Errorf("duplicated symbol %v in Huffman tree", sl.Symbol)
+			}
+			code = (code + 1) << uint(sl.Length-sorted[i-1].Length)
+		}
+		if code >= 1<<uint(sl.Length) {
+			return Tree{}, errors.New("code lengths over-subscribe the Huffman tree")
+		}
+		codes[i] = canonicalCode{symbol: sl.Symbol, code: code, length: sl.Length}
+	}
+
+	last := sorted[len(sorted)-1]
+	if code != 1<<uint(last.Length)-1 {
+		return Tree{}, errors.New("code lengths do not define a complete Huffman tree")
+	}
+
+	return Tree{root: buildCanonical(codes, 0)}, nil
+}
+
+type canonicalCode struct {
+	symbol byte
+	code   uint64
+	length int
+}
+
+func (c canonicalCode) bit(depth int) bool {
+	return c.code&(1<<uint(c.length-depth-1)) != 0
+}
+
+// buildCanonical yields the node for the given codes sharing their first depth bits
+func buildCanonical(codes []canonicalCode, depth int) node {
+	if len(codes) == 1 && codes[0].length == depth {
+		return SymbolFreq{Symbol: codes[0].symbol}
+	}
+
+	var left, right []canonicalCode
+	for _, c := range codes {
+		if c.bit(depth) == rightFlag {
+			right = append(right, c)
+		} else {
+			left = append(left, c)
+		}
+	}
+
+	return intNode{left: buildCanonical(left, depth+1), right: buildCanonical(right, depth+1)}
+}
+
+// AsCanonicalBitstream encodes the symbols and code lengths of this (canonical) tree in a bitstream:
+//   - the number n of symbols, Elias gamma coded
+//   - the first symbol (8 bits)
+//   - if n > 1, the Rice parameter k (3 bits) followed by the other symbols in increasing order,
+//     as Rice coded differences (minus one) with their predecessor
+//   - if n > 2, the bit width w of code lengths minus one (3 bits) followed by the code lengths
+//     minus one (w bits) of all the symbols but the last one.
+//
+// The code length of the last symbol is implied by the others (the code is complete).
+func (t Tree) AsCanonicalBitstream() bitstream.BitStream {
+	lengths := t.CodeLengths()
+	n := len(lengths)
+
+	result := bitstream.New()
+	bitstream.WriteGamma(&result, uint64(n))
+	result.WriteBits(uint64(lengths[0].Symbol), 8)
+	if n == 1 {
+		return result
+	}
+
+	deltas := make([]uint, n-1)
+	for i := 1; i < n; i++ {
+		deltas[i-1] = uint(lengths[i].Symbol-lengths[i-1].Symbol) - 1
+	}
+	k := bestRiceParameter(deltas)
+	result.WriteBits(uint64(k), riceParameterBits)
+	for _, d := range deltas {
+		bitstream.WriteRice(&result, uint64(d), k)
+	}
+
+	if n == 2 {
+		return result
+	}
+
+	width := 1
+	for _, sl := range lengths[:n-1] {
+		for sl.Length-1 >= 1<<uint(width) {
+			width++
+		}
+	}
+	result.WriteBits(uint64(width-1), codeLengthWidthBits)
+	for _, sl := range lengths[:n-1] {
+		result.WriteBits(uint64(sl.Length-1), uint(width))
+	}
+
+	return result
+}
+
+// NewCanonicalTreeFromBS yields a canonical tree from its encoding (see AsCanonicalBitstream)
+func NewCanonicalTreeFromBS(bs bitstream.BitReader) (Tree, error) {
+	n, err := bitstream.ReadGamma(bs)
+	if err != nil {
+		return Tree{}, err
+	}
+	if n > 256 {
+		return Tree{}, fmt.Errorf("%d symbols in a Huffman tree", n)
+	}
+
+	lengths := make([]SymbolLength, n)
+	lengths[0].Symbol, err = bs.ReadByte()
+	if err != nil {
+		return Tree{}, err
+	}
+	if n == 1 {
+		return NewTreeFromCodeLengths(lengths)
+	}
+
+	k, err := bs.ReadBits(riceParameterBits)
+	if err != nil {
+		return Tree{}, err
+	}
+	for i := 1; i < len(lengths); i++ {
+		delta, err := bitstream.ReadRice(bs, uint(k))
+		if err != nil {
+			return Tree{}, err
+		}
+		s := uint64(lengths[i-1].Symbol) + delta + 1
+		if delta > 255 || s > 255 {
+			return Tree{}, fmt.Errorf("symbol %v out of range in canonical Huffman tree", s)
+		}
+		lengths[i].Symbol = byte(s)
+	}
+
+	last := len(lengths) - 1
+	if n == 2 {
+		lengths[0].Length, lengths[1].Length = 1, 1
+		return NewTreeFromCodeLengths(lengths)
+	}
+
+	width, err := bs.ReadBits(codeLengthWidthBits)
+	if err != nil {
+		return Tree{}, err
+	}
+	width++
+
+	// the code being complete, the sum of 2^-length is 1
+	remaining := uint64(1) << maxCodeLength
+	for i := 0; i < last; i++ {
+		l, err := bs.ReadBits(uint(width))
+		if err != nil {
+			return Tree{}, err
+		}
+		lengths[i].Length = int(l) + 1
+		if lengths[i].Length > maxCodeLength {
+			return Tree{}, fmt.Errorf("code length %d longer than %d", lengths[i].Length, maxCodeLength)
+		}
+
+		weight := uint64(1) << uint(maxCodeLength-lengths[i].Length)
+		if weight >= remaining {
+			return Tree{}, errors.New("code lengths over-subscribe the Huffman tree")
+		}
+		remaining -= weight
+	}
+
+	lengths[last].Length = maxCodeLength
+	for remaining > 1 {
+		if remaining&1 != 0 {
+			return Tree{}, errors.New("code lengths do not define a complete Huffman tree")
+		}
+		remaining >>= 1
+		lengths[last].Length--
+	}
+
+	return NewTreeFromCodeLengths(lengths)
+}
+
+// bestRiceParameter yields the Rice parameter giving the shortest encoding of the given values
+func bestRiceParameter(values []uint) uint {
+	best, bestSize := uint(0), uint(0)
+	for k := uint(0); k < 1<<riceParameterBits; k++ {
+		size := uint(0)
+		for _, v := range values {
+			size += v>>k + 1 + k
+		}
+		if k == 0 || size < bestSize {
+			best, bestSize = k, size
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,116 @@
+package huffman
+
+import (
+	"fmt"
+
+	"github.com/chavacava/next/internal/bitstream"
+)
+
+// lookupBits is the maximum number of bits resolved by a single look up in the tables of a Decoder
+const lookupBits = 8
+
+// Decoder decodes symbols coded with a Huffman tree by looking up several bits at once.
+// The decoder is a hierarchy of tables: the root table resolves codes of up to lookupBits bits,
+// longer codes are resolved by the sub-tables of the root table entries and so on.
+// Use the constructor to create new instances
+type Decoder struct {
+	root *lookupTable
+}
+
+// lookupTable resolves the next bits bits of a code
+type lookupTable struct {
+	bits    int
+	entries []lookupEntry
+}
+
+// lookupEntry is either a symbol with the number of bits of its code (relative to the table)
+// or, if sub is not nil, a sub-table resolving the next bits of the codes
+type lookupEntry struct {
+	symbol byte
+	length int
+	sub    *lookupTable
+}
+
+// NewDecoder yields a decoder for the given tree
+func NewDecoder(t Tree) Decoder {
+	return Decoder{root: newLookupTable(t.root)}
+}
+
+func newLookupTable(n node) *lookupTable {
+	bits := depth(n)
+	if bits > lookupBits {
+		bits = lookupBits
+	}
+
+	result := &lookupTable{bits: bits, entries: make([]lookupEntry, 1<<uint(bits))}
+	for i := range result.entries {
+		result.entries[i] = newLookupEntry(n, uint(i), bits)
+	}
+
+	return result
+}
+
+// newLookupEntry yields the entry corresponding to following the given bits bits of code from the node n
+func newLookupEntry(n node, code uint, bits int) lookupEntry {
+	for l := 0; l < bits; l++ {
+		in, ok := n.(intNode)
+		if !ok {
+			return lookupEntry{symbol: n.(SymbolFreq).Symbol, length: l}
+		}
+
+		bit := code&(1<<uint(bits-l-1)) != 0
+		if bit == rightFlag {
+			n = in.right
+		} else {
+			n = in.left
+		}
+	}
+
+	if sf, ok := n.(SymbolFreq); ok {
+		return lookupEntry{symbol: sf.Symbol, length: bits}
+	}
+
+	return lookupEntry{sub: newLookupTable(n)}
+}
+
+// depth yields the length of the longest path from the given node to a leaf
+func depth(n node) int {
+	in, ok := n.(intNode)
+	if !ok {
+		return 0
+	}
+
+	l, r := depth(in.left), depth(in.right)
+	if l > r {
+		return l + 1
+	}
+
+	return r + 1
+}
+
+// Decode yields the next symbol of the given bitstream.
+// Error will arise if the bitstream ends before the end of the code
+func (d Decoder) Decode(bs bitstream.BitReader) (byte, error) {
+	for t := d.root; ; {
+		v, available := bs.Peek(t.bits)
+		e := t.entries[v]
+		if e.sub == nil {
+			if e.length > available {
+				return 0, fmt.Errorf("truncated Huffman code: %d bits available, %d required", available, e.length)
+			}
+
+			return e.symbol, bs.Skip(e.length)
+		}
+
+		if t.bits > available {
+			return 0, fmt.Errorf("truncated Huffman code: %d bits available, more than %d required", available, t.bits)
+		}
+
+		err := bs.Skip(t.bits)
+		if err != nil {
+			return 0, err
+		}
+
+		t = e.sub
+	}
+}
@@ -42,31 +42,46 @@ func NewTree(fs []SymbolFreq) Tree {
 }
 
 // NewTreeFromBS yields a tree from a bitstream encoding of a tree
-func NewTreeFromBS(bs *bitstream.BitStream) Tree {
-	root := newTreeFromBS(bs)
-	return Tree{root: root}
+// Error will arise if the bitstream ends before the end of the tree or if the tree is too deep
+func NewTreeFromBS(bs bitstream.BitReader) (Tree, error) {
+	root, err := newTreeFromBS(bs, 0)
+	if err != nil {
+		return Tree{}, err
+	}
+
+	return Tree{root: root}, nil
 }
 
-func newTreeFromBS(bs *bitstream.BitStream) node {
+// maxTreeDepth is the maximum depth of a tree read from a bitstream
+const maxTreeDepth = 255
+
+func newTreeFromBS(bs bitstream.BitReader, depth int) (node, error) {
+	if depth > maxTreeDepth {
+		return nil, fmt.Errorf("Huffman tree deeper than %d", maxTreeDepth)
+	}
+
 	b, err := bs.Read()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	switch b {
 	case intNodeMarker:
-		newNode := intNode{}
-		left := newTreeFromBS(bs)
-		right := newTreeFromBS(bs)
-		newNode.left = left
-		newNode.right = right
-		return newNode
+		left, err := newTreeFromBS(bs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		right, err := newTreeFromBS(bs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return intNode{left: left, right: right}, nil
 	default: //case leafNodeMarker:
 		newNode := SymbolFreq{}
 		newNode.Symbol, err = bs.ReadByte()
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		return newNode
+		return newNode, nil
 	}
 }
 
@@ -85,7 +100,7 @@ func (t Tree) Dictionary() DictionaryType {
 }
 
 // Interpret yilds a byte by interpreting the given bitstream on this Huffman tree
-func (t Tree) Interpret(bs *bitstream.BitStream) byte {
+func (t Tree) Interpret(bs bitstream.BitReader) byte {
 	return t.walk(t.root, bs)
 }
 
@@ -117,7 +132,7 @@ func (t Tree) asBitstream(bs *bitstream.BitStream, n node) {
 const rightFlag = true
 const leftFlag = false
 
-func (t Tree) walk(n node, bs *bitstream.BitStream) byte {
+func (t Tree) walk(n node, bs bitstream.BitReader) byte {
 	switch nt := n.(type) {
 	case intNode:
 		var child node
@@ -48,7 +48,7 @@ func TestDictionary(t *testing.T) {
 				SymbolFreq{byte(66), 2},
 				SymbolFreq{byte(67), 3},
 			},
-			want: "map[65:{[false false] 0} 66:{[false true] 0} 67:{[true] 0}]",
+			want: "map[65:00 66:01 67:1]",
 		},
 	}
 	for _, tc := range tt {
@@ -94,7 +94,7 @@ func TestInterpret(t *testing.T) {
 	}
 	for name, tc := range tt {
 		t.Run(name, func(t *testing.T) {
-			gotSymbol := tree.Interpret(tc.bs)
+			gotSymbol := tree.Interpret(&tc.bs)
 
 			if gotSymbol != tc.wantSymbol {
 				t.Fatalf("expected symbol %v, got %v", tc.wantSymbol, gotSymbol)
@@ -140,9 +140,297 @@ func TestNewTreeFromBS(t *testing.T) {
 	bs.Append(bitstream.NewFromBits([]bitstream.Bit{true}))
 	bs.Append(bitstream.NewFromFullByte(67))
 
-	got := NewTreeFromBS(bs)
+	truncated := bitstream.NewFromBits([]bitstream.Bit{false, false, true})
+	truncated.Append(bitstream.NewFromByte(65, 7))
+
+	got, err := NewTreeFromBS(&bs)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
 
 	if want.String() != got.String() {
 		t.Fatalf("expected\n\t%v\ngot\n\t%v", want, got)
 	}
+
+	_, err = NewTreeFromBS(&truncated)
+	if err == nil {
+		t.Fatal("error expected reading a truncated tree")
+	}
+}
+
+func TestNewCanonicalTree(t *testing.T) {
+	fs := []SymbolFreq{
+		SymbolFreq{byte(70), 1},
+		SymbolFreq{byte(65), 1},
+		SymbolFreq{byte(66), 2},
+		SymbolFreq{byte(67), 5},
+	}
+
+	tree := NewCanonicalTree(fs)
+
+	wantLengths := "[{65 3} {66 2} {67 1} {70 3}]"
+	if got := fmt.Sprintf("%v", tree.CodeLengths()); got != wantLengths {
+		t.Fatalf("expected code lengths\n\t%v\ngot\n\t%v", wantLengths, got)
+	}
+
+	want := "map[65:110 66:10 67:0 70:111]"
+	if got := fmt.Sprintf("%v", tree.Dictionary()); got != want {
+		t.Fatalf("expected dictionary\n\t%v\ngot\n\t%v", want, got)
+	}
+}
+
+func TestCanonicalBitstream(t *testing.T) {
+	tt := map[string][]SymbolFreq{
+		"1 element": {
+			SymbolFreq{byte(65), 1},
+		},
+		"2 elements": {
+			SymbolFreq{byte(10), 1},
+			SymbolFreq{byte(250), 5},
+		},
+		"3 elements": {
+			SymbolFreq{byte(65), 1},
+			SymbolFreq{byte(66), 2},
+			SymbolFreq{byte(67), 3},
+		},
+		"long codes": {
+			SymbolFreq{byte(1), 1},
+			SymbolFreq{byte(2), 1},
+			SymbolFreq{byte(3), 2},
+			SymbolFreq{byte(4), 4},
+			SymbolFreq{byte(5), 8},
+			SymbolFreq{byte(6), 16},
+			SymbolFreq{byte(7), 32},
+			SymbolFreq{byte(8), 64},
+			SymbolFreq{byte(9), 128},
+			SymbolFreq{byte(10), 256},
+		},
+		"sparse symbols": {
+			SymbolFreq{byte(0), 10},
+			SymbolFreq{byte(1), 1},
+			SymbolFreq{byte(128), 3},
+			SymbolFreq{byte(255), 7},
+		},
+	}
+
+	for name, fs := range tt {
+		t.Run(name, func(t *testing.T) {
+			want := NewCanonicalTree(fs)
+			bs := want.AsCanonicalBitstream()
+
+			got, err := NewCanonicalTreeFromBS(&bs)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if want.String() != got.String() {
+				t.Fatalf("expected\n\t%v\ngot\n\t%v", want, got)
+			}
+		})
+	}
+}
+
+func TestNewTreeFromCodeLengths(t *testing.T) {
+	tt := map[string]struct {
+		lengths []SymbolLength
+		wantErr bool
+	}{
+		"complete":        {lengths: []SymbolLength{{1, 1}, {2, 2}, {3, 2}}, wantErr: false},
+		"single symbol":   {lengths: []SymbolLength{{1, 0}}, wantErr: false},
+		"no symbols":      {lengths: []SymbolLength{}, wantErr: true},
+		"incomplete":      {lengths: []SymbolLength{{1, 1}, {2, 2}}, wantErr: true},
+		"over-subscribed": {lengths: []SymbolLength{{1, 1}, {2, 1}, {3, 1}}, wantErr: true},
+		"duplicated":      {lengths: []SymbolLength{{1, 1}, {1, 1}}, wantErr: true},
+		"too long":        {lengths: []SymbolLength{{1, 1}, {2, 64}}, wantErr: true},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewTreeFromCodeLengths(tc.lengths)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewLengthLimitedTree(t *testing.T) {
+	fibonacci := []SymbolFreq{}
+	a, b := uint(1), uint(1)
+	for s := 0; s < 20; s++ {
+		fibonacci = append(fibonacci, SymbolFreq{byte(s), a})
+		a, b = b, a+b
+	}
+
+	tt := map[string]struct {
+		fs          []SymbolFreq
+		maxLength   int
+		wantLengths string
+		wantErr     bool
+	}{
+		"1 element": {
+			fs:          []SymbolFreq{{65, 1}},
+			maxLength:   1,
+			wantLengths: "[{65 0}]",
+		},
+		"limit not reached": {
+			fs:          []SymbolFreq{{65, 1}, {66, 2}, {67, 3}},
+			maxLength:   15,
+			wantLengths: "[{65 2} {66 2} {67 1}]",
+		},
+		"limited": {
+			fs:          []SymbolFreq{{65, 1}, {66, 1}, {67, 2}, {68, 4}, {69, 8}},
+			maxLength:   3,
+			wantLengths: "[{65 3} {66 3} {67 3} {68 3} {69 1}]",
+		},
+		"fibonacci": {
+			fs:          fibonacci,
+			maxLength:   6,
+			wantLengths: "[{0 6} {1 6} {2 6} {3 6} {4 6} {5 6} {6 6} {7 6} {8 6} {9 6} {10 6} {11 6} {12 6} {13 6} {14 5} {15 4} {16 4} {17 3} {18 2} {19 2}]",
+		},
+		"too many symbols": {
+			fs:        []SymbolFreq{{65, 1}, {66, 1}, {67, 2}, {68, 4}, {69, 8}},
+			maxLength: 2,
+			wantErr:   true,
+		},
+		"no symbols": {
+			fs:        []SymbolFreq{},
+			maxLength: 15,
+			wantErr:   true,
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name, func(t *testing.T) {
+			tree, err := NewLengthLimitedTree(tc.fs, tc.maxLength)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			if got := fmt.Sprintf("%v", tree.CodeLengths()); got != tc.wantLengths {
+				t.Fatalf("expected code lengths\n\t%v\ngot\n\t%v", tc.wantLengths, got)
+			}
+		})
+	}
+}
+
+// fibonacciFrequencies yields the frequencies of n symbols following the Fibonacci sequence,
+// thus giving a Huffman tree as deep as possible
+func fibonacciFrequencies(n int) []SymbolFreq {
+	result := []SymbolFreq{}
+	a, b := uint(1), uint(1)
+	for s := 0; s < n; s++ {
+		result = append(result, SymbolFreq{byte(s), a})
+		a, b = b, a+b
+	}
+
+	return result
+}
+
+// encodeSymbols yields the encoding of the given symbols with the given tree
+func encodeSymbols(tree Tree, symbols []byte) bitstream.BitStream {
+	dictionary := tree.Dictionary()
+	result := bitstream.New()
+	for _, s := range symbols {
+		result.Append(dictionary[s])
+	}
+
+	return result
+}
+
+func TestDecoder(t *testing.T) {
+	tt := map[string][]SymbolFreq{
+		"1 element":  {{65, 1}},
+		"3 elements": {{65, 1}, {66, 2}, {67, 3}},
+		"deep tree":  fibonacciFrequencies(30),
+		"256 elements": func() []SymbolFreq {
+			result := make([]SymbolFreq, 256)
+			for i := range result {
+				result[i] = SymbolFreq{byte(i), uint(i + 1)}
+			}
+			return result
+		}(),
+	}
+
+	for name, fs := range tt {
+		t.Run(name, func(t *testing.T) {
+			tree := NewTree(fs)
+			symbols := []byte{}
+			for _, f := range fs {
+				symbols = append(symbols, f.Symbol, f.Symbol)
+			}
+			bs := encodeSymbols(tree, symbols)
+
+			decoder := NewDecoder(tree)
+			for _, want := range symbols {
+				got, err := decoder.Decode(&bs)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if got != want {
+					t.Fatalf("expected symbol %v, got %v", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	tree := NewTree(fibonacciFrequencies(30))
+
+	for _, sl := range tree.CodeLengths() {
+		code := tree.Dictionary()[sl.Symbol]
+		truncated := []bitstream.Bit{}
+		for i := 0; i < sl.Length-1; i++ {
+			b, _ := code.Read()
+			truncated = append(truncated, bitstream.Bit(b))
+		}
+
+		bs := bitstream.NewFromBits(truncated)
+		if _, err := NewDecoder(tree).Decode(&bs); err == nil {
+			t.Fatalf("expected error decoding the %d first bits of the code of %v", sl.Length-1, sl.Symbol)
+		}
+	}
+}
+
+func benchmarkDecoding(b *testing.B, decode func(Tree, *bitstream.BitStream) byte) {
+	fs := make([]SymbolFreq, 256)
+	for i := range fs {
+		fs[i] = SymbolFreq{byte(i), uint(i*i + 1)}
+	}
+	tree := NewTree(fs)
+
+	symbols := make([]byte, 4096)
+	for i := range symbols {
+		symbols[i] = byte(i * 7)
+	}
+	encoded := encodeSymbols(tree, symbols)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bs := encoded
+		for range symbols {
+			decode(tree, &bs)
+		}
+	}
+}
+
+func BenchmarkInterpret(b *testing.B) {
+	benchmarkDecoding(b, func(tree Tree, bs *bitstream.BitStream) byte {
+		return tree.Interpret(bs)
+	})
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	var decoder Decoder
+	benchmarkDecoding(b, func(tree Tree, bs *bitstream.BitStream) byte {
+		if decoder.root == nil {
+			decoder = NewDecoder(tree)
+		}
+		s, _ := decoder.Decode(bs)
+		return s
+	})
 }
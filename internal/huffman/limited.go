@@ -0,0 +1,108 @@
+package huffman
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DefaultMaxCodeLength is the default length limit of the codes of length-limited trees
+const DefaultMaxCodeLength = 15
+
+// NewLengthLimitedTree yields a canonical tree for the given list of symbol frequencies
+// whose codes are at most maxLength bits long.
+// Code lengths are those of the Huffman code if none of them exceeds the limit,
+// otherwise they are computed with the package-merge algorithm (optimal under the length limit).
+// Error will arise if maxLength bits are not enough to code all the symbols
+func NewLengthLimitedTree(fs []SymbolFreq, maxLength int) (Tree, error) {
+	if len(fs) == 0 {
+		return Tree{}, errors.New("no symbols to build a Huffman tree")
+	}
+	if maxLength < 1 || maxLength > maxCodeLength {
+		return Tree{}, fmt.Errorf("max code length %d out of range [1,%d]", maxLength, maxCodeLength)
+	}
+	if len(fs) > 1<<uint(maxLength) {
+		return Tree{}, fmt.Errorf("%d symbols can not be coded with codes of at most %d bits", len(fs), maxLength)
+	}
+
+	lengths := NewTree(fs).CodeLengths()
+	for _, sl := range lengths {
+		if sl.Length > maxLength {
+			lengths = packageMerge(fs, maxLength)
+			break
+		}
+	}
+
+	return NewTreeFromCodeLengths(lengths)
+}
+
+// pmItem is an item of the package-merge algorithm: a leaf or a package of items
+type pmItem struct {
+	weight uint
+	leaves []int // leaves[i] is the number of occurrences of the i-th symbol in the item
+}
+
+// packageMerge yields the optimal code lengths, limited to maxLength, of the given symbol frequencies.
+// The number of symbols must be in [2, 1 << maxLength]
+func packageMerge(fs []SymbolFreq, maxLength int) []SymbolLength {
+	sorted := make([]SymbolFreq, len(fs))
+	copy(sorted, fs)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count < sorted[j].Count
+		}
+		return sorted[i].Symbol < sorted[j].Symbol
+	})
+
+	n := len(sorted)
+	leaves := make([]pmItem, n)
+	for i, f := range sorted {
+		leaves[i] = pmItem{weight: f.Count, leaves: make([]int, n)}
+		leaves[i].leaves[i] = 1
+	}
+
+	items := leaves
+	for level := 1; level < maxLength; level++ {
+		packages := make([]pmItem, 0, len(items)/2)
+		for i := 0; i+1 < len(items); i += 2 {
+			p := pmItem{weight: items[i].weight + items[i+1].weight, leaves: make([]int, n)}
+			for j := range p.leaves {
+				p.leaves[j] = items[i].leaves[j] + items[i+1].leaves[j]
+			}
+			packages = append(packages, p)
+		}
+
+		items = mergeItems(leaves, packages)
+	}
+
+	// the length of the code of a symbol is the number of selected items containing it
+	result := make([]SymbolLength, n)
+	for i, f := range sorted {
+		result[i].Symbol = f.Symbol
+	}
+	for _, it := range items[:2*n-2] {
+		for j, c := range it.leaves {
+			result[j].Length += c
+		}
+	}
+
+	return result
+}
+
+// mergeItems merges two lists of items sorted by weight, leaves come first on equal weights
+func mergeItems(leaves, packages []pmItem) []pmItem {
+	result := make([]pmItem, 0, len(leaves)+len(packages))
+	i, j := 0, 0
+	for i < len(leaves) && j < len(packages) {
+		if leaves[i].weight <= packages[j].weight {
+			result = append(result, leaves[i])
+			i++
+		} else {
+			result = append(result, packages[j])
+			j++
+		}
+	}
+	result = append(result, leaves[i:]...)
+
+	return append(result, packages[j:]...)
+}
@@ -0,0 +1,178 @@
+package table
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/chavacava/next/internal/types"
+)
+
+// Estimated storage costs, in bits, used to decide which states and transitions of a blended table are worth keeping
+const (
+	leafCost       = 10 // a successor in the record data of a state
+	escapeByteCost = 8  // the escape symbol in the record of a state
+)
+
+// stateCost yields the estimated storage cost, in bits, of the record of a state of the given length
+func stateCost(length int) float64 {
+	return float64(8 * (length + 2)) // record type, state length and state
+}
+
+// NewBlended yields a table with the states of all context orders from order down to 0.
+// The root of the table is empty: the first symbol of the input is a transition from the order 0 state.
+//
+// States and transitions seen too few times to pay for their storage are not kept in the table.
+// When encoding a symbol, if the state of the highest order is not in the table the next lower order one is used.
+// If the state is in the table but the symbol is not one of its successors, the state emits its Escape symbol
+// and the next lower order state is used. The order 0 state has all the symbols of the input as successors.
+//
+// The input is read twice.
+func NewBlended(input io.ReadSeeker, order int) TransitionsTable {
+	if order < 1 {
+		panic(fmt.Sprintf("invalid context order %d, it must be at least 1", order))
+	}
+
+	full := TransitionsTable{Transitions: map[State]*NextList{}}
+	inputSize := forEachTransition(input, order, func(context State, to byte) {
+		full.Update(context, to, func(State, *NextList) {})
+	})
+
+	kept := selectStates(full.Transitions)
+
+	_, err := input.Seek(0, io.SeekStart)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// count the transitions as they will be encoded
+	escapes := map[State]types.SymbolCountType{}
+	forEachTransition(input, order, func(context State, to byte) {
+		for l := len(context); l >= 0; l-- {
+			s := context[len(context)-l:]
+			nl, exists := kept[s]
+			if !exists {
+				continue
+			}
+
+			if n := nl.find(to); n != nil {
+				n.Count++
+				return
+			}
+
+			escapes[s]++
+		}
+	})
+
+	for s, nl := range kept {
+		used := []*next{}
+		for _, n := range nl.List {
+			if n.Count > 0 {
+				used = append(used, n)
+			}
+		}
+		if len(used) == 0 {
+			delete(kept, s)
+			continue
+		}
+
+		nl.List = used
+		if escapes[s] > 0 {
+			nl.Escape = &next{S: nl.freeSymbol(), Count: escapes[s]}
+			nl.List = append(nl.List, nl.Escape)
+		}
+	}
+
+	return TransitionsTable{
+		Order:       order,
+		Blended:     true,
+		InputSize:   inputSize,
+		Transitions: kept,
+	}
+}
+
+// forEachTransition calls f for each symbol of the input with the context (up to order bytes) preceding it.
+// It yields the size of the input
+func forEachTransition(input io.Reader, order int, f func(context State, to byte)) types.Size {
+	var p = make([]byte, 1)
+	var inputSize types.Size
+	context := State("")
+	for {
+		_, err := input.Read(p)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			panic(err.Error())
+		}
+
+		f(context, p[0])
+		context = context.Next(p[0], order)
+		inputSize++
+	}
+
+	return inputSize
+}
+
+// selectStates yields, from the given full statistics, the states and successors worth keeping.
+// Counts of the returned lists are set to zero.
+func selectStates(full map[State]*NextList) map[State]*NextList {
+	result := map[State]*NextList{}
+	for s, nl := range full {
+		if len(s) == 0 {
+			continue
+		}
+
+		lower := full[s[1:]]
+		lowerTotal := lower.Total()
+		total := nl.Total()
+		kept := newNextList()
+		escapes := total
+		gain := -stateCost(len(s))
+		for _, n := range nl.List {
+			g := float64(n.Count)*(bits(lower.find(n.S).Count, lowerTotal)-bits(n.Count, total)) - leafCost
+			if g <= 0 {
+				continue
+			}
+
+			kept.List = append(kept.List, &next{S: n.S})
+			gain += g
+			escapes -= n.Count
+		}
+
+		if escapes > 0 {
+			gain -= escapeByteCost + leafCost + float64(escapes)*bits(escapes, total)
+		}
+
+		if len(kept.List) > 0 && gain > 0 {
+			result[s] = &kept
+		}
+	}
+
+	if order0, exists := full[""]; exists {
+		kept := newNextList()
+		for _, n := range order0.List {
+			kept.List = append(kept.List, &next{S: n.S})
+		}
+		result[""] = &kept
+	}
+
+	return result
+}
+
+// bits yields the estimated number of bits to encode a symbol seen count times out of total
+func bits(count, total types.SymbolCountType) float64 {
+	return math.Log2(float64(total) / float64(count))
+}
+
+// freeSymbol yields the lowest byte that is not a successor in this list
+func (nl *NextList) freeSymbol() byte {
+	for s := 0; s < 256; s++ {
+		if nl.find(byte(s)) == nil {
+			return byte(s)
+		}
+	}
+
+	panic(fmt.Sprintf("no free symbol in next list %v", nl))
+}
@@ -1,46 +1,49 @@
 package table
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
+
+	"github.com/chavacava/next/internal/types"
 )
 
 func TestNextListAdd(t *testing.T) {
 
 	tt := []struct {
 		s         byte
-		wantIndex nextIndex
-		wantCount symbolCountType
+		wantIndex types.NextIndex
+		wantCount types.SymbolCountType
 	}{
 		{
 			s:         byte(0),
-			wantIndex: nextIndex(0),
-			wantCount: symbolCountType(1),
+			wantIndex: types.NextIndex(0),
+			wantCount: types.SymbolCountType(1),
 		},
 		{
 			s:         byte(250),
-			wantIndex: nextIndex(1),
-			wantCount: symbolCountType(1),
+			wantIndex: types.NextIndex(1),
+			wantCount: types.SymbolCountType(1),
 		},
 		{
 			s:         byte(0),
-			wantIndex: nextIndex(0),
-			wantCount: symbolCountType(2),
+			wantIndex: types.NextIndex(0),
+			wantCount: types.SymbolCountType(2),
 		},
 		{
 			s:         byte(0),
-			wantIndex: nextIndex(0),
-			wantCount: symbolCountType(3),
+			wantIndex: types.NextIndex(0),
+			wantCount: types.SymbolCountType(3),
 		},
 		{
 			s:         byte(250),
-			wantIndex: nextIndex(1),
-			wantCount: symbolCountType(2),
+			wantIndex: types.NextIndex(1),
+			wantCount: types.SymbolCountType(2),
 		},
 		{
 			s:         byte(128),
-			wantIndex: nextIndex(2),
-			wantCount: symbolCountType(1),
+			wantIndex: types.NextIndex(2),
+			wantCount: types.SymbolCountType(1),
 		},
 	}
 	nl := newNextList()
@@ -63,3 +66,145 @@ func TestNextListAdd(t *testing.T) {
 		}
 	}
 }
+
+func TestNew(t *testing.T) {
+	tt := map[string]struct {
+		input    string
+		order    int
+		wantRoot State
+		want     map[State]string
+	}{
+		"order 1": {
+			input:    "abcab",
+			order:    1,
+			wantRoot: "a",
+			want:     map[State]string{"a": "[98,2]", "b": "[99,1]", "c": "[97,1]"},
+		},
+		"order 2": {
+			input:    "abcabd",
+			order:    2,
+			wantRoot: "ab",
+			want:     map[State]string{"ab": "[99,1][100,1]", "bc": "[97,1]", "ca": "[98,1]"},
+		},
+		"input shorter than order": {
+			input:    "ab",
+			order:    3,
+			wantRoot: "ab",
+			want:     map[State]string{},
+		},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				got := New(bytes.NewReader([]byte(tc.input)), tc.order)
+
+				if got.Root != tc.wantRoot {
+					t.Fatalf("expected root %q, got %q", tc.wantRoot, got.Root)
+				}
+
+				if got.InputSize != types.Size(len(tc.input)) {
+					t.Fatalf("expected input size %d, got %d", len(tc.input), got.InputSize)
+				}
+
+				if len(got.Transitions) != len(tc.want) {
+					t.Fatalf("expected %d states, got %d", len(tc.want), len(got.Transitions))
+				}
+
+				for s, want := range tc.want {
+					nl, ok := got.Transitions[s]
+					if !ok {
+						t.Fatalf("expected state %q in the table", s)
+					}
+					if nl.String() != want {
+						t.Fatalf("expected next list of %q to be %v, got %v", s, want, nl.String())
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestNewBlended(t *testing.T) {
+	input := "Simplicity is prerequisite for reliability. Simplicity is prerequisite for reliability."
+
+	for order := 1; order <= 4; order++ {
+		got := NewBlended(bytes.NewReader([]byte(input)), order)
+
+		if got.Root != "" {
+			t.Fatalf("expected empty root, got %q", got.Root)
+		}
+
+		order0, ok := got.Transitions[""]
+		if !ok {
+			t.Fatalf("expected order 0 state in the table of order %d", order)
+		}
+		if order0.Escape != nil {
+			t.Fatalf("expected order 0 state without escape, got %v", order0.Escape)
+		}
+
+		// each symbol of the input is encoded exactly once
+		var encoded types.SymbolCountType
+		for s, nl := range got.Transitions {
+			if len(s) > order {
+				t.Fatalf("state %q longer than order %d", s, order)
+			}
+			for _, n := range nl.List {
+				if n == nl.Escape {
+					continue
+				}
+				if nl.Escape != nil && n.S == nl.Escape.S {
+					t.Fatalf("escape symbol %v of state %q is also a successor", n.S, s)
+				}
+				encoded += n.Count
+			}
+		}
+
+		if encoded != types.SymbolCountType(len(input)) {
+			t.Fatalf("order %d: expected %d encoded symbols, got %d", order, len(input), encoded)
+		}
+	}
+}
+
+func TestNextListWithEscape(t *testing.T) {
+	nl := newNextList()
+	for _, s := range []byte{0, 1, 0, 3} {
+		nl.add(s)
+	}
+
+	got := nl.WithEscape()
+	if got.String() != "[0,2][1,1][3,1][2,3]" {
+		t.Fatalf("expected list with escape [0,2][1,1][3,1][2,3], got %v", got.String())
+	}
+	if got.Escape == nil || got.Escape.S != 2 {
+		t.Fatalf("expected escape symbol 2, got %v", got.Escape)
+	}
+	if got.Has(2) {
+		t.Fatalf("escape symbol must not be a successor")
+	}
+	if nl.Escape != nil || len(nl.List) != 3 {
+		t.Fatalf("expected the original list to be unchanged, got %v", nl.String())
+	}
+
+	full := newNextList()
+	for s := 0; s < 256; s++ {
+		full.add(byte(s))
+	}
+	if got := full.WithEscape(); got.Escape != nil {
+		t.Fatalf("expected no escape for a list with all the byte values, got %v", got.Escape)
+	}
+}
+
+func TestGrows(t *testing.T) {
+	// transitions from 'a' at positions 0 (a), 1 (b), 3 (b), 5 (b), 7 (c), 9 (d), 11 (e), 13 (b)
+	// the index of c requires 2 bits, the index of e requires 3 bits
+	tt := New(bytes.NewReader([]byte("aabababacadaeab")), 1)
+
+	nl := tt.Transitions["a"]
+	if got := fmt.Sprintf("%v", nl.Grows); got != "[7 11]" {
+		t.Fatalf("expected grows [7 11], got %v", got)
+	}
+	if got := fmt.Sprintf("%v", nl.GrowTotals); got != "[4 6]" {
+		t.Fatalf("expected grow totals [4 6], got %v", got)
+	}
+}
@@ -18,8 +18,13 @@ func (n next) String() string {
 }
 
 type NextList struct {
-	List  []*next
+	List []*next
+	// Grows are the positions, in the input, of the transitions requiring one more bit to code the index of the successor
 	Grows []types.Position
+	// GrowTotals[i] is the number of transitions counted before the transition at position Grows[i]
+	GrowTotals []types.SymbolCountType
+	// Escape, when not nil, is the entry of List standing for the successors not in the list
+	Escape *next
 }
 
 func newNextList() NextList {
@@ -44,6 +49,49 @@ func (nl *NextList) add(s byte) types.NextIndex {
 	return types.NextIndex(len(nl.List) - 1)
 }
 
+// Has returns true if s is one of the successors in this list
+func (nl *NextList) Has(s byte) bool {
+	n := nl.find(s)
+	return n != nil && n != nl.Escape
+}
+
+func (nl *NextList) find(s byte) *next {
+	for _, n := range nl.List {
+		if n.S == s {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// Total yields the number of transitions counted in this list
+func (nl *NextList) Total() types.SymbolCountType {
+	var result types.SymbolCountType
+	for _, n := range nl.List {
+		result += n.Count
+	}
+
+	return result
+}
+
+// WithEscape yields a copy of this list with an Escape entry counted as many times as the list has successors.
+// The copy has no Escape entry if the list has all the 256 byte values as successors
+func (nl *NextList) WithEscape() NextList {
+	result := newNextList()
+	for _, n := range nl.List {
+		c := *n
+		result.List = append(result.List, &c)
+	}
+
+	if len(result.List) < 256 {
+		result.Escape = &next{S: result.freeSymbol(), Count: types.SymbolCountType(len(result.List))}
+		result.List = append(result.List, result.Escape)
+	}
+
+	return result
+}
+
 func (nl *NextList) dynamicBitCount(pos types.Position) byte {
 	last := byte(0)
 	for i, p := range nl.Grows {
@@ -64,27 +112,57 @@ func (nl *NextList) String() string {
 	return result
 }
 
-// TransitionsTable of symbols and their transitions
+// State represents a state of the transducer, that is the last Order bytes of the input
+type State string
+
+// Next yields the state reached from this one after reading the byte b in a transducer of the given order
+func (s State) Next(b byte, order int) State {
+	result := s + State([]byte{b})
+	if len(result) > order {
+		result = result[1:]
+	}
+
+	return result
+}
+
+// TransitionsTable of states and their transitions
 // use the constructor New
 type TransitionsTable struct {
-	Root        byte
+	Order int
+	// Blended is true if the table holds states of all context orders from Order down to 0 (see NewBlended)
+	Blended bool
+	// Adaptive is true if the table is updated while encoding or decoding (see NewAdaptive)
+	Adaptive    bool
+	Root        State
 	InputSize   types.Size
-	Transitions map[byte]*NextList
+	Transitions map[State]*NextList
 }
 
-// New table from byte stream
-func New(input io.ReadSeeker) TransitionsTable {
-	table := TransitionsTable{Transitions: map[byte]*NextList{}}
+// New table of the given context order from byte stream
+// The root of the table will be the first order bytes of the input
+func New(input io.ReadSeeker, order int) TransitionsTable {
+	if order < 1 {
+		panic(fmt.Sprintf("invalid context order %d, it must be at least 1", order))
+	}
+
+	table := TransitionsTable{Order: order, Transitions: map[State]*NextList{}}
 
 	var p = make([]byte, 1)
 	var pSize = types.Size(len(p))
-	_, err := input.Read(p)
-	if err != nil {
-		return table
-	}
-	var inputSize = pSize
+	var inputSize types.Size
+	for len(table.Root) < order {
+		_, err := input.Read(p)
+		if err != nil {
+			if err == io.EOF {
+				table.InputSize = inputSize
+				return table
+			}
 
-	table.Root = byte(p[0])
+			panic(err.Error())
+		}
+		inputSize += pSize
+		table.Root += State(p)
+	}
 
 	previous := table.Root
 	var pos types.Position = 0
@@ -101,7 +179,7 @@ func New(input io.ReadSeeker) TransitionsTable {
 		current := byte(p[0])
 		table.addNext(previous, current, pos)
 		inputSize += pSize
-		previous = current
+		previous = previous.Next(current, order)
 		pos++
 	}
 
@@ -110,7 +188,28 @@ func New(input io.ReadSeeker) TransitionsTable {
 	return table
 }
 
-func (t *TransitionsTable) addNext(from byte, to byte, pos types.Position) {
+// NewAdaptive yields an empty blended table of the given context order.
+// The table is meant to be updated, with Update, after encoding (or decoding) each symbol
+func NewAdaptive(order int) TransitionsTable {
+	if order < 1 {
+		panic(fmt.Sprintf("invalid context order %d, it must be at least 1", order))
+	}
+
+	return TransitionsTable{Order: order, Blended: true, Adaptive: true, Transitions: map[State]*NextList{}}
+}
+
+// Update counts a transition to the given symbol from each of the states of orders 0 to len(context) that end the context.
+// The given function is called with each updated state and its list.
+func (t *TransitionsTable) Update(context State, to byte, updated func(State, *NextList)) {
+	for l := 0; l <= len(context); l++ {
+		s := context[len(context)-l:]
+		updated(s, t.add(s, to))
+	}
+}
+
+// add counts a transition from the given state to the given symbol, the state is added to the table if needed.
+// It yields the list of the state
+func (t *TransitionsTable) add(from State, to byte) *NextList {
 	nexts, exists := t.Transitions[from]
 	if !exists {
 		nl := newNextList()
@@ -120,13 +219,24 @@ func (t *TransitionsTable) addNext(from byte, to byte, pos types.Position) {
 
 	nexts.add(to)
 
+	return nexts
+}
+
+func (t *TransitionsTable) addNext(from State, to byte, pos types.Position) {
+	nexts := t.add(from, to)
+
 	currentNecessaryBits := byte(len(nexts.Grows)) + 1
 	if minBitsCount(len(nexts.List)-1) > currentNecessaryBits {
 		nexts.Grows = append(nexts.Grows, pos)
+		nexts.GrowTotals = append(nexts.GrowTotals, nexts.Total()-1)
 	}
 }
 
 // minBitsCount yields the minimum number of bits required to encode the given number n.
 func minBitsCount(n int) byte {
+	if n < 1 {
+		return 1
+	}
+
 	return byte(math.Log2(float64(n))) + 1
 }
@@ -0,0 +1,385 @@
+// Package next implements reading and writing of next compressed files, as package compress/gzip does for gzip files.
+//
+// Files are written as framed files: the content is cut into blocks compressed independently of each other.
+// Files of all the format versions, framed or not, can be read.
+package next
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/chavacava/next/internal/compressor"
+	"github.com/chavacava/next/internal/table"
+)
+
+// Model identifies how the transitions of the content are modeled
+type Model int
+
+// Models
+const (
+	// Transducer stores the transitions of the states of a fixed context order
+	Transducer Model = iota
+	// Blended stores the transitions of the states of context orders from the given order down to 0
+	Blended
+	// Adaptive stores no transitions, they are learnt while decoding
+	Adaptive
+)
+
+// Coder identifies the entropy coder of the transitions
+type Coder int
+
+// Coders
+const (
+	Huffman Coder = iota
+	Arithmetic
+	RANS
+)
+
+// DefaultBlockSize is the default maximum length of the content of a block
+const DefaultBlockSize = compressor.DefaultBlockSize
+
+// ErrClosed is the error of writing to a closed Writer
+var ErrClosed = errors.New("next: write to a closed writer")
+
+// Header is the information about the original content stored in the header of a compressed file.
+// All the fields are optional
+type Header struct {
+	Name    string      // file name
+	Comment string      // free form comment
+	ModTime time.Time   // modification time
+	Mode    os.FileMode // permission and mode bits
+}
+
+// config holds the compression parameters set by the options
+type config struct {
+	model     Model
+	order     int
+	coder     Coder
+	blockSize int
+	workers   int
+}
+
+// Option sets a compression parameter of a Writer
+type Option func(*config)
+
+// WithModel sets the model of the transitions, Transducer by default
+func WithModel(m Model) Option {
+	return func(c *config) { c.model = m }
+}
+
+// WithOrder sets the context order, the number of bytes of a state, from 1 (default) to 255
+func WithOrder(order int) Option {
+	return func(c *config) { c.order = order }
+}
+
+// WithCoder sets the entropy coder of the transitions, Huffman by default.
+// The adaptive model only supports the Huffman coder
+func WithCoder(coder Coder) Option {
+	return func(c *config) { c.coder = coder }
+}
+
+// WithBlockSize sets the maximum length of the content of a block, DefaultBlockSize by default.
+// Blocks are held in memory while they are compressed
+func WithBlockSize(size int) Option {
+	return func(c *config) { c.blockSize = size }
+}
+
+// WithWorkers sets the number of blocks compressed concurrently, 1 by default.
+// The compressed content does not depend on the number of workers, but as many blocks are held in memory
+func WithWorkers(n int) Option {
+	return func(c *config) { c.workers = n }
+}
+
+var coders = map[Coder]compressor.Coder{
+	Huffman:    compressor.CoderHuffman,
+	Arithmetic: compressor.CoderArithmetic,
+	RANS:       compressor.CoderRANS,
+}
+
+// blockCompressor yields the compressor of the blocks of the given configuration.
+// Error will arise if a compression parameter is invalid
+func (c config) blockCompressor() (compressor.BlockCompressor, error) {
+	var newTable func(io.ReadSeeker, int) table.TransitionsTable
+	switch c.model {
+	case Transducer:
+		newTable = table.New
+	case Blended:
+		newTable = table.NewBlended
+	case Adaptive:
+		newTable = func(_ io.ReadSeeker, order int) table.TransitionsTable { return table.NewAdaptive(order) }
+	default:
+		return compressor.BlockCompressor{}, fmt.Errorf("next: unknown model %d", c.model)
+	}
+	if c.order < 1 || c.order > 255 {
+		return compressor.BlockCompressor{}, fmt.Errorf("next: context order %d out of [1,255]", c.order)
+	}
+	coder, ok := coders[c.coder]
+	if !ok {
+		return compressor.BlockCompressor{}, fmt.Errorf("next: unknown coder %d", c.coder)
+	}
+	if c.model == Adaptive && coder != compressor.CoderHuffman {
+		return compressor.BlockCompressor{}, fmt.Errorf("next: coder %d not supported by the adaptive model, its transitions are Huffman coded", c.coder)
+	}
+	if c.blockSize <= 0 {
+		return compressor.BlockCompressor{}, fmt.Errorf("next: invalid block size %d", c.blockSize)
+	}
+	if c.workers < 1 {
+		return compressor.BlockCompressor{}, fmt.Errorf("next: invalid number of workers %d", c.workers)
+	}
+
+	return compressor.NewBlockCompressor(newTable, c.order, coder, c.blockSize).WithWorkers(c.workers), nil
+}
+
+// Writer is an io.WriteCloser, writes to a Writer are compressed and written to the underlying writer.
+// The header is written on the first call to Write, Flush or Close: the Header fields must be set before
+type Writer struct {
+	Header
+
+	w       io.Writer
+	config  config
+	fw      *compressor.FramedWriter // nil until the header is written
+	full    [][]byte                 // full blocks not yet written, written once there is one per worker
+	pending []byte                   // content of the block being filled
+	closed  bool
+	err     error
+}
+
+// NewWriter yields a new Writer of a compressed file to w, with the compression parameters of the given options.
+// It is the caller's responsibility to call Close on the Writer when done, the underlying writer is not closed.
+// Errors of invalid options are returned by the first call to Write, Flush or Close
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	c := config{model: Transducer, order: 1, coder: Huffman, blockSize: DefaultBlockSize, workers: 1}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	z := &Writer{config: c}
+	z.Reset(w)
+
+	return z
+}
+
+// Reset discards the state of the Writer and makes it equivalent to the result of NewWriter
+// with the same options, but writing to w. The Header fields are cleared
+func (z *Writer) Reset(w io.Writer) {
+	*z = Writer{
+		w:      w,
+		config: z.config,
+	}
+}
+
+// start writes the header, if not already written
+func (z *Writer) start() error {
+	if z.fw != nil {
+		return nil
+	}
+
+	c, err := z.config.blockCompressor()
+	if err != nil {
+		return err
+	}
+	c = c.WithMetadata(compressor.Metadata{
+		Name:    z.Name,
+		Comment: z.Comment,
+		ModTime: z.ModTime,
+		Mode:    z.Mode,
+	})
+
+	z.fw, err = c.NewFramedWriter(z.w)
+
+	return err
+}
+
+// Write compresses p, the content is written to the underlying writer block by block
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, ErrClosed
+	}
+	z.err = z.start()
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		k := z.config.blockSize - len(z.pending)
+		if k > len(p) {
+			k = len(p)
+		}
+		z.pending = append(z.pending, p[:k]...)
+		p = p[k:]
+		n += k
+
+		if len(z.pending) == z.config.blockSize {
+			z.full = append(z.full, z.pending)
+			z.pending = nil
+		}
+		if len(z.full) == z.config.workers {
+			z.err = z.writeBlocks()
+			if z.err != nil {
+				return n, z.err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// writeBlocks compresses and writes the full blocks then the pending content as blocks
+func (z *Writer) writeBlocks() error {
+	blocks := append(z.full, z.pending)
+	err := z.fw.WriteBlocks(blocks)
+	// the slice is reused, its blocks are released to be collected
+	for i := range blocks {
+		blocks[i] = nil
+	}
+	z.full = blocks[:0]
+	z.pending = nil
+
+	return err
+}
+
+// Flush compresses and writes the pending content as blocks, the last one possibly shorter,
+// every byte written so far can then be decompressed from the underlying writer.
+// Flushing often degrades the compression ratio
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.err = z.start()
+	if z.err == nil {
+		z.err = z.writeBlocks()
+	}
+
+	return z.err
+}
+
+// Close writes the pending content, the end of the compressed file and its index.
+// It does not close the underlying writer
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.err = z.Flush()
+	z.closed = true
+	if z.err == nil {
+		z.err = z.fw.Close()
+	}
+
+	return z.err
+}
+
+// Reader is an io.ReadCloser, reads from a Reader yield the decompressed content of the underlying reader.
+// The content of each block of a framed file is yielded once its integrity is checked
+type Reader struct {
+	Header
+
+	pr     *io.PipeReader
+	src    *stoppableReader
+	closed bool
+}
+
+// NewReader yields a new Reader of the compressed file read from r, the header is read at once.
+// It is the caller's responsibility to call Close on the Reader when done, the underlying reader is not closed.
+// The content is decompressed by a goroutine that reads ahead, thus the Reader may read from r
+// more bytes than the compressed file holds.
+// Error will arise if the header can not be read
+func NewReader(r io.Reader) (*Reader, error) {
+	z := new(Reader)
+	err := z.Reset(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+// Reset discards the state of the Reader and makes it equivalent to the result of NewReader on r
+func (z *Reader) Reset(r io.Reader) error {
+	z.Close()
+	*z = Reader{}
+
+	headerBytes := new(bytes.Buffer)
+	h, err := compressor.ReadHeader(io.TeeReader(r, headerBytes))
+	if err != nil {
+		return fmt.Errorf("next: %v", err)
+	}
+	z.Header = Header{
+		Name:    h.Metadata.Name,
+		Comment: h.Metadata.Comment,
+		ModTime: h.Metadata.ModTime,
+		Mode:    h.Metadata.Mode,
+	}
+
+	// the content is decompressed as it is read
+	pr, pw := io.Pipe()
+	src := &stoppableReader{r: r}
+	z.pr, z.src = pr, src
+	go func() {
+		err := compressor.NewDecompressor().Decompress(io.MultiReader(headerBytes, src), pw)
+		pw.CloseWithError(err)
+	}()
+
+	return nil
+}
+
+// Read reads up to len(p) bytes of decompressed content
+func (z *Reader) Read(p []byte) (int, error) {
+	if z.pr == nil {
+		return 0, errors.New("next: read from a reader without header")
+	}
+	if z.closed {
+		return 0, errors.New("next: read from a closed reader")
+	}
+
+	return z.pr.Read(p)
+}
+
+// Close stops the decompression, it does not close the underlying reader.
+// Close does not wait for the decompression goroutine: if it is blocked reading the underlying reader
+// it ends once that read returns, the bytes read are then discarded
+func (z *Reader) Close() error {
+	if z.pr == nil || z.closed {
+		return nil
+	}
+	z.closed = true
+
+	z.src.stop()
+
+	return z.pr.Close()
+}
+
+// errStopped is the error of reading from a stopped reader
+var errStopped = errors.New("next: reader closed")
+
+// stoppableReader reads from the underlying reader until it is stopped
+type stoppableReader struct {
+	r       io.Reader
+	stopped int32
+}
+
+func (sr *stoppableReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&sr.stopped) != 0 {
+		return 0, errStopped
+	}
+
+	return sr.r.Read(p)
+}
+
+func (sr *stoppableReader) stop() {
+	atomic.StoreInt32(&sr.stopped, 1)
+}
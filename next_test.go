@@ -0,0 +1,320 @@
+package next
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chavacava/next/internal/compressor"
+	"github.com/chavacava/next/internal/table"
+)
+
+var content = strings.Repeat("Simplicity is prerequisite for reliability. ", 50)
+
+// compress yields the compressed content written to a Writer of the given options, in chunks of the given size
+func compress(t *testing.T, content string, chunk int, opts ...Option) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	z := NewWriter(buf, opts...)
+	for p := []byte(content); len(p) > 0; {
+		k := chunk
+		if k > len(p) {
+			k = len(p)
+		}
+		n, err := z.Write(p[:k])
+		if err != nil || n != k {
+			t.Fatalf("expected to write %d bytes, got %d, %v", k, n, err)
+		}
+		p = p[k:]
+	}
+	err := z.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing the writer %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	tt := map[string]struct {
+		content string
+		chunk   int
+		opts    []Option
+	}{
+		"default options":         {content, 100, nil},
+		"empty":                   {"", 1, nil},
+		"blended order 3":         {content, 7, []Option{WithModel(Blended), WithOrder(3)}},
+		"adaptive order 2":        {content, 1000, []Option{WithModel(Adaptive), WithOrder(2)}},
+		"blended rANS":            {content, 1000, []Option{WithModel(Blended), WithOrder(2), WithCoder(RANS)}},
+		"small blocks arithmetic": {content, 33, []Option{WithBlockSize(100), WithCoder(Arithmetic)}},
+		"chunks of the block":     {content, 64, []Option{WithBlockSize(64)}},
+		"workers":                 {content, 150, []Option{WithBlockSize(100), WithWorkers(4)}},
+	}
+
+	for name, tc := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				compressed := compress(t, tc.content, tc.chunk, tc.opts...)
+
+				z, err := NewReader(bytes.NewReader(compressed))
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				got, err := ioutil.ReadAll(z)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if string(got) != tc.content {
+					t.Fatalf("expected\n\t%q\ngot\n\t%q", tc.content, got)
+				}
+				if err := z.Close(); err != nil {
+					t.Fatalf("unexpected error closing the reader %v", err)
+				}
+			},
+		)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	want := Header{Name: "notes.txt", Comment: "a comment", ModTime: time.Unix(1600000000, 0), Mode: 0640}
+	buf := new(bytes.Buffer)
+	z := NewWriter(buf)
+	z.Header = want
+	if _, err := io.WriteString(z, content); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer r.Close()
+	if !reflect.DeepEqual(want, r.Header) {
+		t.Fatalf("expected\n\t%+v\ngot\n\t%+v", want, r.Header)
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	buf := new(bytes.Buffer)
+	z := NewWriter(buf, WithBlockSize(1000))
+	io.WriteString(z, content[:150])
+	if err := z.Flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	flushed := append([]byte{}, buf.Bytes()...)
+
+	io.WriteString(z, content[150:])
+	if err := z.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the content written before the flush is decompressed from the bytes written before the flush
+	r, err := NewReader(bytes.NewReader(flushed))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	got := make([]byte, 150)
+	if _, err := io.ReadFull(r, got); err != nil || string(got) != content[:150] {
+		t.Fatalf("expected to read the flushed content, got %q, %v", got, err)
+	}
+	r.Close()
+
+	r, err = NewReader(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	all, err := ioutil.ReadAll(r)
+	if err != nil || string(all) != content {
+		t.Fatalf("expected to read the whole content, got %q, %v", all, err)
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	first := new(bytes.Buffer)
+	z := NewWriter(first, WithModel(Blended), WithOrder(2))
+	z.Comment = "first"
+	io.WriteString(z, "discarded content")
+	z.Flush()
+
+	second := new(bytes.Buffer)
+	z.Reset(second)
+	io.WriteString(z, content)
+	if err := z.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if want := compress(t, content, len(content), WithModel(Blended), WithOrder(2)); !bytes.Equal(want, second.Bytes()) {
+		t.Fatal("expected the reset writer to write as a new writer of the same options")
+	}
+}
+
+func TestWriterWorkers(t *testing.T) {
+	want := compress(t, content, 70, WithBlockSize(100))
+	for _, workers := range []int{2, 5, 64} {
+		if got := compress(t, content, 70, WithBlockSize(100), WithWorkers(workers)); !bytes.Equal(want, got) {
+			t.Fatalf("expected the same compressed content with %d workers as with one worker", workers)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	tt := map[string][]Option{
+		"order 0":         {WithOrder(0)},
+		"order 256":       {WithOrder(256)},
+		"unknown model":   {WithModel(Model(7))},
+		"unknown coder":   {WithCoder(Coder(7))},
+		"adaptive rANS":   {WithModel(Adaptive), WithCoder(RANS)},
+		"zero block size": {WithBlockSize(0)},
+		"no worker":       {WithWorkers(0)},
+	}
+
+	for name, opts := range tt {
+		t.Run(name,
+			func(t *testing.T) {
+				z := NewWriter(new(bytes.Buffer), opts...)
+				if _, err := z.Write([]byte("abc")); err == nil {
+					t.Fatal("error expected")
+				}
+				if err := z.Close(); err == nil {
+					t.Fatal("error expected closing the writer")
+				}
+			},
+		)
+	}
+
+	z := NewWriter(new(bytes.Buffer))
+	if err := z.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("unexpected error closing twice %v", err)
+	}
+	if _, err := z.Write([]byte("abc")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected error %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestReaderSingleStreamFile(t *testing.T) {
+	for _, model := range []string{"transducer", "adaptive"} {
+		t.Run(model,
+			func(t *testing.T) {
+				tt := table.New(strings.NewReader(content), 2)
+				if model == "adaptive" {
+					tt = table.NewAdaptive(2)
+				}
+				compressed := new(bytes.Buffer)
+				err := compressor.NewCompressor(tt).Compress(strings.NewReader(content), compressed)
+				if err != nil {
+					t.Fatalf("unexpected compression error %v", err)
+				}
+
+				z, err := NewReader(compressed)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				got, err := ioutil.ReadAll(z)
+				if err != nil || string(got) != content {
+					t.Fatalf("expected to read the content, got %q, %v", got, err)
+				}
+			},
+		)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	compressed := compress(t, content, len(content), WithBlockSize(100))
+
+	if _, err := NewReader(bytes.NewReader([]byte("not compressed"))); err == nil {
+		t.Fatal("error expected reading a header")
+	}
+
+	damaged := append([]byte{}, compressed...)
+	damaged[len(damaged)/2] ^= 0xff
+	z, err := NewReader(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := ioutil.ReadAll(z); err == nil {
+		t.Fatal("error expected reading damaged content")
+	}
+	z.Close()
+
+	// closing before the end stops the decompression
+	z, err = NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := io.ReadFull(z, make([]byte, 10)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := z.Read(make([]byte, 10)); err == nil {
+		t.Fatal("error expected reading a closed reader")
+	}
+
+	if err := z.Reset(bytes.NewReader(compressed)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	got, err := ioutil.ReadAll(z)
+	if err != nil || string(got) != content {
+		t.Fatalf("expected to read the content after a reset, got %d bytes, %v", len(got), err)
+	}
+}
+
+// blockingReader signals the first call to Read, calls to Read return once it is released
+type blockingReader struct {
+	reading chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (br *blockingReader) Read(p []byte) (int, error) {
+	br.once.Do(func() { close(br.reading) })
+	<-br.release
+
+	return 0, io.EOF
+}
+
+func TestReaderCloseBlockedSource(t *testing.T) {
+	compressed := compress(t, content, len(content), WithBlockSize(100))
+
+	// the source yields the header of the file then never returns
+	r := bytes.NewReader(compressed)
+	if _, err := compressor.ReadHeader(r); err != nil {
+		t.Fatalf("unexpected error reading the header %v", err)
+	}
+	header := compressed[:len(compressed)-r.Len()]
+	blocked := &blockingReader{reading: make(chan struct{}), release: make(chan struct{})}
+	defer close(blocked.release)
+	z, err := NewReader(io.MultiReader(bytes.NewReader(header), blocked))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	<-blocked.reading
+
+	closed := make(chan error)
+	go func() { closed <- z.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked by the source")
+	}
+
+	if _, err := z.Read(make([]byte, 10)); err == nil {
+		t.Fatal("error expected reading a closed reader")
+	}
+}